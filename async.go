@@ -0,0 +1,188 @@
+package fasthttpsocket
+
+import (
+	"github.com/pkg/errors"
+	"github.com/trafficstars/fasthttp"
+	"github.com/trafficstars/spinlock"
+)
+
+var (
+	ErrAsyncQueueFull = errors.New(`[fasthttp-socket-client] the queue of asynchronous requests is full`)
+	ErrNotStarted     = errors.New(`[fasthttp-socket-client] the client is not started or is closed`)
+)
+
+const (
+	defaultAsyncQueueSize = 1024
+)
+
+// AsyncCallback is called with the result of an asynchronous request. It's
+// called from a worker goroutine of the client, so it shouldn't block.
+type AsyncCallback func(ctx *fasthttp.RequestCtx, err error)
+
+// Future is the result of an asynchronous request which will be available
+// after the request is done.
+type Future struct {
+	ctx  *fasthttp.RequestCtx
+	err  error
+	done chan struct{}
+}
+
+func newFuture(ctx *fasthttp.RequestCtx) *Future {
+	return &Future{ctx: ctx, done: make(chan struct{})}
+}
+
+func (future *Future) resolve(err error) {
+	future.err = err
+	close(future.done)
+}
+
+// Done returns a channel which is closed when the request is done
+func (future *Future) Done() <-chan struct{} {
+	return future.done
+}
+
+// Wait waits until the request is done and returns its error. The response
+// is in the ctx passed to SendFuture.
+func (future *Future) Wait() error {
+	<-future.done
+	return future.err
+}
+
+// Ctx returns the ctx passed to SendFuture
+func (future *Future) Ctx() *fasthttp.RequestCtx {
+	return future.ctx
+}
+
+type asyncRequest struct {
+	ctx      *fasthttp.RequestCtx
+	callback AsyncCallback
+}
+
+// asyncQueue is a bounded queue of requests which are sent by worker
+// goroutines (see resize).
+type asyncQueue struct {
+	spinlock.Locker
+
+	requests   chan asyncRequest
+	stopChan   chan struct{}
+	retireChan chan struct{}
+	send       func(ctx *fasthttp.RequestCtx) error
+	workers    int
+}
+
+func (queue *asyncQueue) start(size, workerCount int, send func(ctx *fasthttp.RequestCtx) error) {
+	if size <= 0 {
+		size = defaultAsyncQueueSize
+	}
+
+	isStarted := false
+	queue.LockDo(func() {
+		if queue.stopChan != nil { // is already started
+			return
+		}
+		queue.requests = make(chan asyncRequest, size)
+		queue.stopChan = make(chan struct{})
+		queue.retireChan = make(chan struct{})
+		queue.send = send
+		queue.workers = 0
+		isStarted = true
+	})
+	if isStarted {
+		queue.resize(workerCount)
+	}
+}
+
+// resize starts or stops workers to have "workerCount" of them (at least
+// one). Stopped workers finish their current requests first.
+func (queue *asyncQueue) resize(workerCount int) {
+	if workerCount <= 0 {
+		workerCount = 1
+	}
+	queue.LockDo(func() {
+		if queue.stopChan == nil {
+			return
+		}
+		for ; queue.workers < workerCount; queue.workers++ {
+			go queue.worker(queue.requests, queue.stopChan, queue.retireChan, queue.send)
+		}
+		if queue.workers > workerCount {
+			go retireWorkers(queue.workers-workerCount, queue.stopChan, queue.retireChan)
+			queue.workers = workerCount
+		}
+	})
+}
+
+func retireWorkers(count int, stopChan, retireChan chan struct{}) {
+	for i := 0; i < count; i++ {
+		select {
+		case <-stopChan:
+			return
+		case retireChan <- struct{}{}:
+		}
+	}
+}
+
+func (queue *asyncQueue) worker(
+	requests chan asyncRequest,
+	stopChan, retireChan chan struct{},
+	send func(ctx *fasthttp.RequestCtx) error,
+) {
+	for {
+		select {
+		case <-stopChan:
+			return
+		case <-retireChan:
+			return
+		case request := <-requests:
+			request.callback(request.ctx, send(request.ctx))
+		}
+	}
+}
+
+// stop stops workers, queued requests are completed with ErrNotStarted
+func (queue *asyncQueue) stop() {
+	var requests chan asyncRequest
+	queue.LockDo(func() {
+		if queue.stopChan == nil {
+			return
+		}
+		close(queue.stopChan)
+		queue.stopChan = nil
+		requests = queue.requests
+	})
+
+	for {
+		select {
+		case request := <-requests:
+			request.callback(request.ctx, ErrNotStarted)
+		default:
+			return
+		}
+	}
+}
+
+func (queue *asyncQueue) push(ctx *fasthttp.RequestCtx, callback AsyncCallback) (err error) {
+	queue.LockDo(func() {
+		if queue.stopChan == nil {
+			err = ErrNotStarted
+			return
+		}
+		select {
+		case queue.requests <- asyncRequest{ctx: ctx, callback: callback}:
+		default:
+			err = ErrAsyncQueueFull
+		}
+	})
+	return
+}
+
+func (queue *asyncQueue) pushFuture(ctx *fasthttp.RequestCtx) *Future {
+	future := newFuture(ctx)
+	err := queue.push(ctx, func(ctx *fasthttp.RequestCtx, err error) {
+		future.resolve(err)
+	})
+	if err != nil {
+		future.resolve(err)
+	}
+	return future
+}
@@ -0,0 +1,84 @@
+package fasthttpsocket
+
+import (
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/trafficstars/fasthttp"
+)
+
+func TestAsyncQueue(t *testing.T) {
+	var queue asyncQueue
+	ctx := &fasthttp.RequestCtx{}
+
+	assert.Equal(t, ErrNotStarted, queue.pushFuture(ctx).Wait())
+
+	sendErr := errors.New("test")
+	release := make(chan struct{})
+	queue.start(1, 1, func(ctx *fasthttp.RequestCtx) error {
+		<-release
+		return sendErr
+	})
+
+	futures := []*Future{queue.pushFuture(ctx)}
+	for len(queue.requests) > 0 { // waiting until the worker takes the first request
+		runtime.Gosched()
+	}
+	futures = append(futures, queue.pushFuture(ctx))
+	assert.Equal(t, ErrAsyncQueueFull, queue.push(ctx, func(*fasthttp.RequestCtx, error) {}))
+
+	close(release)
+	for _, future := range futures {
+		assert.Equal(t, sendErr, future.Wait())
+		assert.True(t, future.Ctx() == ctx)
+	}
+
+	queue.stop()
+	assert.Equal(t, ErrNotStarted, queue.push(ctx, func(*fasthttp.RequestCtx, error) {}))
+}
+
+func TestAsyncQueueResize(t *testing.T) {
+	var queue asyncQueue
+	var active int32
+	release := make(chan struct{})
+	queue.start(10, 1, func(ctx *fasthttp.RequestCtx) error {
+		atomic.AddInt32(&active, 1)
+		<-release
+		atomic.AddInt32(&active, -1)
+		return nil
+	})
+	defer queue.stop()
+
+	var futures []*Future
+	for i := 0; i < 3; i++ {
+		futures = append(futures, queue.pushFuture(&fasthttp.RequestCtx{}))
+	}
+	assert.True(t, waitFor(func() bool { return atomic.LoadInt32(&active) == 1 }))
+
+	queue.resize(3)
+	assert.True(t, waitFor(func() bool { return atomic.LoadInt32(&active) == 3 }))
+	for range futures {
+		release <- struct{}{}
+	}
+	for _, future := range futures {
+		assert.NoError(t, future.Wait())
+	}
+
+	queue.resize(1)
+	time.Sleep(10 * time.Millisecond) // idle workers are retired
+	futures = futures[:0]
+	for i := 0; i < 3; i++ {
+		futures = append(futures, queue.pushFuture(&fasthttp.RequestCtx{}))
+	}
+	assert.True(t, waitFor(func() bool { return atomic.LoadInt32(&active) == 1 }))
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&active))
+	close(release)
+	for _, future := range futures {
+		assert.NoError(t, future.Wait())
+	}
+}
@@ -0,0 +1,356 @@
+package fasthttpsocket
+
+import (
+	"bufio"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/trafficstars/fasthttp"
+)
+
+const (
+	batchWriteBufferSize = 64 * 1024
+
+	// a window of pipelined requests is limited, so the server is never
+	// blocked on writing responses while the client is blocked on writing
+	// requests (the client reads responses only after writing the window)
+	batchMaxInFlightRequests = 32
+	batchMaxInFlightBytes    = batchWriteBufferSize
+)
+
+// canPipeline returns false if responses couldn't be separated when several
+// of them are in a stream socket at once (the "native" serializer of models
+// without a wire format of their own).
+func (c *SocketClientConn) canPipeline() bool {
+	if !c.Family.isStream() || c.DataModel.isWireProtocol() {
+		return true
+	}
+	_, isNative := c.Encoder.(*dummyEncoder)
+	return !isNative
+}
+
+// sendAndReceiveBatch sends requests of "ctxs" and receives responses into
+// them, the error of each request is stored into "errs". If possible,
+// requests are pipelined: a window of requests is sent before receiving
+// responses (and on stream sockets they are written with a single syscall).
+func (c *SocketClientConn) sendAndReceiveBatch(ctxs []*fasthttp.RequestCtx, errs []error) {
+	if !c.canPipeline() {
+		for idx, ctx := range ctxs {
+			_, errs[idx] = c.sendAndReceiveSafe(ctx)
+		}
+		return
+	}
+
+	isPending := make([]bool, len(ctxs))
+	queue := make([]int, len(ctxs)) // indexes of requests to be sent
+	for idx := range ctxs {
+		isPending[idx] = true
+		queue[idx] = idx
+	}
+	for len(queue) > 0 {
+		queue = c.pipelineSafe(ctxs, errs, isPending, queue)
+	}
+}
+
+func (c *SocketClientConn) pipelineSafe(ctxs []*fasthttp.RequestCtx, errs []error, isPending []bool, queue []int) (rest []int) {
+	defer func() { // gob.Decoder panics sometimes
+		if r := recover(); r != nil {
+			c.writeBuffer = nil
+			c.setBroken()
+			failPending(errs, isPending, fmt.Errorf("panic: %v", r))
+			rest = nil
+		}
+	}()
+	return c.pipeline(ctxs, errs, isPending, queue)
+}
+
+// failBatch sets "err" for all requests without an error
+func failBatch(errs []error, err error) {
+	for idx := range errs {
+		if errs[idx] == nil {
+			errs[idx] = err
+		}
+	}
+}
+
+// failPending sets "err" for all requests which are not completed, yet
+func failPending(errs []error, isPending []bool, err error) {
+	for idx := range errs {
+		if isPending[idx] {
+			errs[idx] = err
+			isPending[idx] = false
+		}
+	}
+}
+
+// pipeline sends a window of requests from "queue" (indexes of "ctxs", see
+// batchMaxInFlightRequests and batchMaxInFlightBytes) and then receives
+// their responses (if the model carries request IDs then responses may be
+// received in any order). It returns indexes of requests which should be
+// sent next: the rest of "queue" and requests of the window which were not
+// answered before the server closed the connection. All pending requests
+// fail if the connection is broken.
+func (c *SocketClientConn) pipeline(ctxs []*fasthttp.RequestCtx, errs []error, isPending []bool, queue []int) []int {
+	request := c.Request
+	response := c.Response
+
+	if c.Family.isStream() && c.Messanger != nil {
+		if c.batchWriteBuffer == nil {
+			c.batchWriteBuffer = bufio.NewWriterSize(c.Messanger, batchWriteBufferSize)
+		} else {
+			c.batchWriteBuffer.Reset(c.Messanger)
+		}
+		c.writeBuffer = c.batchWriteBuffer
+	}
+
+	var idxByRequestID map[uint64]int
+	if _, ok := request.(requestIDCarrier); ok {
+		idxByRequestID = make(map[uint64]int, batchMaxInFlightRequests)
+	}
+
+	window := make([]int, 0, batchMaxInFlightRequests) // indexes of sent requests
+	writtenBytes := c.writtenBytes
+	queued := 0
+	for _, idx := range queue {
+		if len(window) >= batchMaxInFlightRequests || c.writtenBytes-writtenBytes >= batchMaxInFlightBytes {
+			break
+		}
+		queued++
+
+		ctx := ctxs[idx]
+		if err := c.ModelCodec.Encode(request, ctx); err != nil {
+			errs[idx] = err
+			isPending[idx] = false
+			continue
+		}
+		if idxByRequestID != nil {
+			idxByRequestID[c.setRequestID(request)] = idx
+		}
+		if carrier, ok := request.(connInfoCarrier); ok {
+			carrier.connInfo().fill(ctx)
+		}
+		if carrier, ok := request.(userValuesCarrier); ok {
+			carrier.userValues().fill(ctx, c.UserValueKeys)
+		}
+
+		if err := c.Encoder.Encode(request); err != nil {
+			c.writeBuffer = nil
+			c.setBroken()
+			failPending(errs, isPending, err)
+			return nil
+		}
+		window = append(window, idx)
+	}
+	rest := queue[queued:]
+
+	if writeBuffer := c.writeBuffer; writeBuffer != nil {
+		c.writeBuffer = nil
+		if err := writeBuffer.Flush(); err != nil {
+			c.setBroken()
+			failPending(errs, isPending, err)
+			return nil
+		}
+	}
+
+	isClosing := false
+	for received := 0; received < len(window); received++ {
+		response.Reset()
+		if err := c.Decoder.Decode(response); err != nil {
+			if isClosing { // the server closed the connection
+				break
+			}
+			c.setBroken()
+			failPending(errs, isPending, err)
+			return nil
+		}
+
+		idx := -1
+		if carrier, ok := response.(requestIDCarrier); ok {
+			if requestIdx, ok := idxByRequestID[*carrier.requestID()]; ok && isPending[requestIdx] {
+				idx = requestIdx
+			}
+		} else {
+			idx = window[received]
+		}
+		if idx < 0 {
+			c.setBroken() // the stream is out of sync
+			failPending(errs, isPending, ErrUnexpectedResponseID)
+			return nil
+		}
+		ctx := ctxs[idx]
+		isPending[idx] = false
+
+		errs[idx] = c.ModelCodec.Decode(ctx, response)
+		if errs[idx] == nil {
+			if carrier, ok := response.(userValuesCarrier); ok {
+				carrier.userValues().apply(ctx, c.ResponseUserValueKeys, false)
+			}
+		}
+
+		if closer, ok := response.(connectionCloser); ok && closer.ConnectionClose() {
+			isClosing = true
+			if idxByRequestID == nil { // the rest of responses will never come
+				break
+			}
+			// responses of requests handled before closing may still come
+		}
+	}
+
+	if !isClosing {
+		return rest
+	}
+	if err := c.Reconnect(); err != nil {
+		c.setBroken()
+		failPending(errs, isPending, err)
+		return nil
+	}
+	var unanswered []int
+	for _, idx := range window {
+		if isPending[idx] {
+			unanswered = append(unanswered, idx)
+		}
+	}
+	return append(unanswered, rest...)
+}
+
+// acquireClientConnections locks and returns up to "maxCount" connections.
+// Busy connections are waited for according to the RetryPolicy if there's no
+// free connection at all.
+func (sock *SocketClient) acquireClientConnections(maxCount int) (conns []*SocketClientConn, err error) {
+	for attempt := 1; ; attempt++ {
+		for len(conns) < maxCount {
+			var conn *SocketClientConn
+			conn, err = sock.acquireClientConnection()
+			if err != nil {
+				break
+			}
+			conns = append(conns, conn)
+		}
+		if len(conns) > 0 {
+			return conns, nil
+		}
+		if attempt >= sock.RetryPolicy.MaxAttempts || !sock.RetryPolicy.isRetryable(err) {
+			return nil, err
+		}
+		time.Sleep(sock.RetryPolicy.backoff(attempt))
+	}
+}
+
+// SendBatch sends requests of "ctxs" and receives responses into them.
+// Requests are spread over free connections and are pipelined within a
+// connection. Returns the error of each request (failed requests are not
+// repeated).
+func (sock *SocketClient) SendBatch(ctxs []*fasthttp.RequestCtx) []error {
+	errs := make([]error, len(ctxs))
+	if len(ctxs) == 0 {
+		return errs
+	}
+
+	cb := sock.CircuitBreaker
+	var cbGeneration uint64
+	if cb != nil {
+		var err error
+		if cbGeneration, err = cb.Allow(); err != nil {
+			failBatch(errs, err)
+			return errs
+		}
+	}
+
+	conns, err := sock.acquireClientConnections(len(ctxs))
+	if err != nil {
+		if cb != nil {
+			if err == ErrBusy {
+				cb.Cancel(cbGeneration)
+			} else {
+				cb.Failure(cbGeneration)
+			}
+		}
+		failBatch(errs, err)
+		return errs
+	}
+
+	var wg sync.WaitGroup
+	chunkSize := (len(ctxs) + len(conns) - 1) / len(conns)
+	for idx, conn := range conns {
+		start := idx * chunkSize
+		end := start + chunkSize
+		if end > len(ctxs) {
+			end = len(ctxs)
+		}
+		if start >= end {
+			conn.release()
+			continue
+		}
+		wg.Add(1)
+		go func(conn *SocketClientConn, ctxs []*fasthttp.RequestCtx, errs []error) {
+			defer wg.Done()
+			conn.sendAndReceiveBatch(ctxs, errs)
+			conn.release()
+		}(conn, ctxs[start:end], errs[start:end])
+	}
+	wg.Wait()
+
+	if cb != nil {
+		isSuccess := true
+		for _, err := range errs {
+			if err != nil {
+				isSuccess = false
+				break
+			}
+		}
+		if isSuccess {
+			cb.Success(cbGeneration)
+		} else {
+			cb.Failure(cbGeneration)
+		}
+	}
+	return errs
+}
+
+// SendBatch sends requests of "ctxs" (grouped by backends according to the
+// Strategy) the same way as SocketClient.SendBatch.
+func (sock *BalancedSocketClient) SendBatch(ctxs []*fasthttp.RequestCtx) []error {
+	errs := make([]error, len(ctxs))
+
+	groups := map[*SocketBackend][]int{}
+	for idx, ctx := range ctxs {
+		backend := sock.pickBackend(ctx, nil)
+		if backend == nil {
+			errs[idx] = ErrNoHealthyBackends
+			continue
+		}
+		groups[backend] = append(groups[backend], idx)
+	}
+
+	var wg sync.WaitGroup
+	for backend, idxs := range groups {
+		wg.Add(1)
+		go func(backend *SocketBackend, idxs []int) {
+			defer wg.Done()
+			groupCtxs := make([]*fasthttp.RequestCtx, 0, len(idxs))
+			for _, idx := range idxs {
+				groupCtxs = append(groupCtxs, ctxs[idx])
+			}
+			groupErrs := sock.sendBatchTo(backend, groupCtxs)
+			for i, idx := range idxs {
+				errs[idx] = groupErrs[i]
+			}
+		}(backend, idxs)
+	}
+	wg.Wait()
+
+	return errs
+}
+
+func (sock *BalancedSocketClient) sendBatchTo(backend *SocketBackend, ctxs []*fasthttp.RequestCtx) []error {
+	atomic.AddInt64(&backend.inFlight, int64(len(ctxs)))
+	errs := backend.SendBatch(ctxs)
+	atomic.AddInt64(&backend.inFlight, -int64(len(ctxs)))
+
+	for _, err := range errs {
+		sock.reportBackendResult(backend, err)
+	}
+	return errs
+}
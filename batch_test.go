@@ -0,0 +1,218 @@
+package fasthttpsocket
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trafficstars/fasthttp"
+)
+
+// sendTestBatch sends requests with paths "/0", "/1", ... and bodies of
+// "bodySize" bytes, it fails the test if the batch isn't sent in 10 seconds.
+func sendTestBatch(t *testing.T, client *SocketClient, count, bodySize int) ([]*fasthttp.RequestCtx, []error) {
+	ctxs := make([]*fasthttp.RequestCtx, count)
+	for idx := range ctxs {
+		ctxs[idx] = &fasthttp.RequestCtx{}
+		ctxs[idx].Request.Header.SetMethod("POST")
+		ctxs[idx].Request.SetRequestURI("/" + strconv.Itoa(idx))
+		ctxs[idx].Request.SetBody(bytes.Repeat([]byte{byte('a' + idx%26)}, bodySize))
+	}
+
+	errsChan := make(chan []error, 1)
+	go func() {
+		errsChan <- client.SendBatch(ctxs)
+	}()
+	select {
+	case errs := <-errsChan:
+		return ctxs, errs
+	case <-time.After(10 * time.Second):
+		t.Fatal("SendBatch is stuck")
+		return nil, nil
+	}
+}
+
+func TestSendBatch(t *testing.T) {
+	for _, address := range []string{
+		"fasthttp:gob:unix:",          // pipelined via a stream
+		"fasthttp:native:unixpacket:", // pipelined via messages
+		"fasthttp:native:unix:",       // can't be pipelined
+	} {
+		address += filepath.Join(t.TempDir(), "batch.sock")
+		t.Run(address[:len(address)-len(t.TempDir())-len("/batch.sock")-1], func(t *testing.T) {
+			srv := startTestServer(t, address, func(ctx *fasthttp.RequestCtx) {
+				idx, _ := strconv.Atoi(string(ctx.Path()[1:]))
+				time.Sleep(time.Duration(idx%3) * time.Millisecond) // responses are reordered by concurrent handling
+				ctx.SetBodyString(string(ctx.Path()) + ":")
+				ctx.Response.AppendBody(ctx.PostBody())
+			})
+			defer srv.Stop()
+
+			client, err := NewSocketClient(Config{Address: address})
+			if !assert.NoError(t, err) {
+				return
+			}
+			if !assert.NoError(t, client.Start(2)) {
+				return
+			}
+			defer client.Close()
+
+			// big requests and responses don't fit into socket buffers at once
+			bodySize := 16 * 1024
+			ctxs, errs := sendTestBatch(t, client, 200, bodySize)
+			for idx, ctx := range ctxs {
+				if !assert.NoError(t, errs[idx]) {
+					continue
+				}
+				body := ctx.Response.Body()
+				prefix := "/" + strconv.Itoa(idx) + ":"
+				assert.Equal(t, prefix, string(body[:len(prefix)]))
+				assert.Equal(t, bodySize, len(body)-len(prefix))
+				assert.Equal(t, byte('a'+idx%26), body[len(body)-1])
+			}
+		})
+	}
+}
+
+func TestSendBatchCanPipeline(t *testing.T) {
+	for address, canPipeline := range map[string]bool{
+		"fasthttp:gob:unix:/nonexistent.sock":          true,
+		"fasthttp:native:unixpacket:/nonexistent.sock": true,
+		"http1:native:unix:/nonexistent.sock":          true,
+		"fasthttp:native:unix:/nonexistent.sock":       false,
+	} {
+		client, err := NewSocketClient(Config{Address: address})
+		if !assert.NoError(t, err) {
+			continue
+		}
+		if assert.NoError(t, client.Start(1)) {
+			assert.Equal(t, canPipeline, client.clientConns[0].canPipeline(), address)
+		}
+		client.Close()
+	}
+}
+
+func TestSendBatchConnectionClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "http1.sock")
+	listener, err := net.Listen("unix", path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var connCount int32
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/close" {
+				w.Header().Set("Connection", "close")
+			}
+			_, _ = fmt.Fprintf(w, "path %s", r.URL.Path)
+		}),
+		ConnState: func(conn net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				atomic.AddInt32(&connCount, 1)
+			}
+		},
+	}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Close()
+
+	client, err := NewSocketClient(Config{
+		Address: "http1:native:unix:" + path,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.NoError(t, client.Start(1)) {
+		return
+	}
+	defer client.Close()
+
+	// requests after "/close" are not handled by the server, they are sent
+	// again via a new connection
+	paths := []string{"/a", "/close", "/b", "/c"}
+	ctxs := make([]*fasthttp.RequestCtx, len(paths))
+	for idx, path := range paths {
+		ctxs[idx] = &fasthttp.RequestCtx{}
+		ctxs[idx].Request.SetRequestURI(path)
+	}
+	errs := client.SendBatch(ctxs)
+	for idx, path := range paths {
+		if assert.NoError(t, errs[idx], path) {
+			assert.Equal(t, "path "+path, string(ctxs[idx].Response.Body()))
+		}
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&connCount))
+}
+
+func TestSendBatchHeadRequests(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "http1.sock")
+	listener, err := net.Listen("unix", path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var connCount int32
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body := "path " + r.URL.Path
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			_, _ = fmt.Fprint(w, body)
+		}),
+		ConnState: func(conn net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				atomic.AddInt32(&connCount, 1)
+			}
+		},
+	}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Close()
+
+	client, err := NewSocketClient(Config{
+		Address: "http1:native:unix:" + path,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.NoError(t, client.Start(1)) {
+		return
+	}
+	defer client.Close()
+
+	// responses to HEAD requests have Content-Length, but don't have bodies
+	methods := []string{"HEAD", "GET", "GET", "HEAD", "HEAD", "GET"}
+	ctxs := make([]*fasthttp.RequestCtx, len(methods))
+	for idx, method := range methods {
+		ctxs[idx] = &fasthttp.RequestCtx{}
+		ctxs[idx].Request.Header.SetMethod(method)
+		ctxs[idx].Request.SetRequestURI("/" + strconv.Itoa(idx))
+	}
+	errs := client.SendBatch(ctxs)
+	for idx, method := range methods {
+		if !assert.NoError(t, errs[idx], idx) {
+			continue
+		}
+		body := "path /" + strconv.Itoa(idx)
+		assert.Equal(t, len(body), ctxs[idx].Response.Header.ContentLength(), idx)
+		if method == "HEAD" {
+			assert.Empty(t, ctxs[idx].Response.Body(), idx)
+		} else {
+			assert.Equal(t, body, string(ctxs[idx].Response.Body()), idx)
+		}
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&connCount))
+
+	// the connection is still in sync
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/last")
+	assert.NoError(t, client.SendAndReceive(ctx))
+	assert.Equal(t, "path /last", string(ctx.Response.Body()))
+}
@@ -0,0 +1,289 @@
+package fasthttpsocket
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/trafficstars/spinlock"
+)
+
+var (
+	ErrCircuitOpen = errors.New(`[fasthttp-socket-client] circuit breaker is open`)
+)
+
+const (
+	defaultCircuitFailureRateThreshold = 0.5
+	defaultCircuitMinRequests          = 20
+	defaultCircuitWindow               = 10 * time.Second
+	defaultCircuitOpenTimeout          = time.Second
+	defaultCircuitHalfOpenMaxRequests  = 1
+)
+
+type CircuitState int
+
+const (
+	CircuitClosed = CircuitState(iota)
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+func (state CircuitState) String() string {
+	switch state {
+	case CircuitClosed:
+		return `closed`
+	case CircuitOpen:
+		return `open`
+	case CircuitHalfOpen:
+		return `half-open`
+	default:
+		return ``
+	}
+}
+
+// CircuitBreaker stops sending requests to a backend which fails too often.
+//
+// While the circuit is closed all requests are allowed and failures are
+// counted within Window. If there were at least MinRequests requests and the
+// failure rate reached FailureRateThreshold then the circuit opens and all
+// requests fail fast with ErrCircuitOpen. Every OpenTimeout the backend is
+// probed (see Probe) and the circuit becomes half-open if the probe
+// succeeds: up to HalfOpenMaxRequests probe requests are allowed, if they
+// succeed the circuit closes, otherwise it opens again.
+type CircuitBreaker struct {
+	spinlock.Locker
+
+	// Name identifies the circuit in OnStateChange (BalancedSocketClient sets
+	// it to the address of a backend).
+	Name string
+
+	FailureRateThreshold float64
+	MinRequests          int
+	Window               time.Duration
+	OpenTimeout          time.Duration
+	HalfOpenMaxRequests  int
+
+	// OnStateChange (if set) is called on every state change.
+	OnStateChange func(cb *CircuitBreaker, from, to CircuitState)
+
+	// Probe (if set) is called every OpenTimeout while the circuit is open,
+	// the circuit becomes half-open only if it returns true. SocketClient
+	// sets it to check that it has an established connection (they are
+	// reconnected in the background). Without Probe the circuit becomes
+	// half-open after OpenTimeout on the next request.
+	Probe func() bool
+
+	state            CircuitState
+	generation       uint64
+	closeChan        chan struct{}
+	windowStart      time.Time
+	requests         int
+	failures         int
+	openedAt         time.Time
+	halfOpenInFlight int
+	halfOpenSuccess  int
+}
+
+// NewCircuitBreaker returns a CircuitBreaker with default settings.
+func NewCircuitBreaker() *CircuitBreaker {
+	return &CircuitBreaker{
+		FailureRateThreshold: defaultCircuitFailureRateThreshold,
+		MinRequests:          defaultCircuitMinRequests,
+		Window:               defaultCircuitWindow,
+		OpenTimeout:          defaultCircuitOpenTimeout,
+		HalfOpenMaxRequests:  defaultCircuitHalfOpenMaxRequests,
+	}
+}
+
+// Clone returns a new CircuitBreaker with the same settings (except Probe).
+func (cb *CircuitBreaker) Clone() *CircuitBreaker {
+	return &CircuitBreaker{
+		Name:                 cb.Name,
+		FailureRateThreshold: cb.FailureRateThreshold,
+		MinRequests:          cb.MinRequests,
+		Window:               cb.Window,
+		OpenTimeout:          cb.OpenTimeout,
+		HalfOpenMaxRequests:  cb.HalfOpenMaxRequests,
+		OnStateChange:        cb.OnStateChange,
+	}
+}
+
+// Close stops probing (the circuit remains in its current state).
+func (cb *CircuitBreaker) Close() {
+	cb.LockDo(func() {
+		if cb.closeChan == nil {
+			cb.closeChan = make(chan struct{})
+		}
+		select {
+		case <-cb.closeChan:
+		default:
+			close(cb.closeChan)
+		}
+	})
+}
+
+// IsOpen returns true if requests are rejected right now (the circuit is open
+// and there was no successful probe, yet).
+func (cb *CircuitBreaker) IsOpen() (r bool) {
+	cb.LockDo(func() {
+		r = cb.state == CircuitOpen && (cb.Probe != nil || time.Since(cb.openedAt) < cb.OpenTimeout)
+	})
+	return
+}
+
+func (cb *CircuitBreaker) State() (r CircuitState) {
+	cb.LockDo(func() {
+		r = cb.state
+	})
+	return
+}
+
+// setState should be called with locked "cb"
+func (cb *CircuitBreaker) setState(state CircuitState, now time.Time) {
+	cb.state = state
+	cb.generation++
+	cb.windowStart = now
+	cb.requests = 0
+	cb.failures = 0
+	cb.halfOpenInFlight = 0
+	cb.halfOpenSuccess = 0
+	if state == CircuitOpen {
+		cb.openedAt = now
+		if cb.Probe != nil {
+			if cb.closeChan == nil {
+				cb.closeChan = make(chan struct{})
+			}
+			go cb.probeLoop(cb.generation, cb.closeChan)
+		}
+	}
+}
+
+// probeLoop calls Probe every OpenTimeout until it succeeds (the circuit
+// becomes half-open) or the circuit leaves the state of "generation".
+func (cb *CircuitBreaker) probeLoop(generation uint64, closeChan chan struct{}) {
+	timer := time.NewTimer(cb.OpenTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-closeChan:
+			return
+		case <-timer.C:
+		}
+
+		isSuccess := cb.Probe()
+
+		var isStale bool
+		cb.LockDo(func() {
+			isStale = cb.generation != generation
+			if isStale || !isSuccess {
+				return
+			}
+			cb.setState(CircuitHalfOpen, time.Now())
+		})
+		if isStale {
+			return
+		}
+		if isSuccess {
+			cb.notify(CircuitOpen, CircuitHalfOpen)
+			return
+		}
+		timer.Reset(cb.OpenTimeout)
+	}
+}
+
+func (cb *CircuitBreaker) notify(from, to CircuitState) {
+	if from == to || cb.OnStateChange == nil {
+		return
+	}
+	cb.OnStateChange(cb, from, to)
+}
+
+// Allow returns ErrCircuitOpen if a request shouldn't be sent. Otherwise the
+// result of the request should be reported via Success, Failure or Cancel
+// with the returned generation: results of requests allowed before the last
+// state change are ignored.
+func (cb *CircuitBreaker) Allow() (generation uint64, err error) {
+	var from, to CircuitState
+	now := time.Now()
+	cb.LockDo(func() {
+		from = cb.state
+		if cb.state == CircuitOpen && cb.Probe == nil && now.Sub(cb.openedAt) >= cb.OpenTimeout {
+			cb.setState(CircuitHalfOpen, now)
+		}
+		to = cb.state
+		generation = cb.generation
+
+		switch cb.state {
+		case CircuitOpen:
+			err = ErrCircuitOpen
+		case CircuitHalfOpen:
+			if cb.halfOpenInFlight >= cb.HalfOpenMaxRequests {
+				err = ErrCircuitOpen
+				return
+			}
+			cb.halfOpenInFlight++
+		}
+	})
+	cb.notify(from, to)
+	return
+}
+
+// Success reports a successful request.
+func (cb *CircuitBreaker) Success(generation uint64) {
+	cb.report(generation, true)
+}
+
+// Failure reports a failed request.
+func (cb *CircuitBreaker) Failure(generation uint64) {
+	cb.report(generation, false)
+}
+
+// Cancel reports a request which was allowed, but wasn't sent.
+func (cb *CircuitBreaker) Cancel(generation uint64) {
+	cb.LockDo(func() {
+		if cb.generation == generation && cb.state == CircuitHalfOpen && cb.halfOpenInFlight > 0 {
+			cb.halfOpenInFlight--
+		}
+	})
+}
+
+func (cb *CircuitBreaker) report(generation uint64, isSuccess bool) {
+	var from, to CircuitState
+	now := time.Now()
+	cb.LockDo(func() {
+		from = cb.state
+		if cb.generation != generation { // the request is allowed in another state
+			to = from
+			return
+		}
+		switch cb.state {
+		case CircuitClosed:
+			if now.Sub(cb.windowStart) > cb.Window {
+				cb.windowStart = now
+				cb.requests = 0
+				cb.failures = 0
+			}
+			cb.requests++
+			if !isSuccess {
+				cb.failures++
+			}
+			if cb.requests >= cb.MinRequests &&
+				float64(cb.failures) >= cb.FailureRateThreshold*float64(cb.requests) {
+				cb.setState(CircuitOpen, now)
+			}
+		case CircuitHalfOpen:
+			if cb.halfOpenInFlight > 0 {
+				cb.halfOpenInFlight--
+			}
+			if !isSuccess {
+				cb.setState(CircuitOpen, now)
+				break
+			}
+			cb.halfOpenSuccess++
+			if cb.halfOpenSuccess >= cb.HalfOpenMaxRequests {
+				cb.setState(CircuitClosed, now)
+			}
+		}
+		to = cb.state
+	})
+	cb.notify(from, to)
+}
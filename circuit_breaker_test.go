@@ -0,0 +1,148 @@
+package fasthttpsocket
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var transitions []CircuitState
+	cb := NewCircuitBreaker()
+	cb.MinRequests = 4
+	cb.OpenTimeout = 10 * time.Millisecond
+	cb.OnStateChange = func(cb *CircuitBreaker, from, to CircuitState) {
+		transitions = append(transitions, to)
+	}
+
+	for i := 0; i < 4; i++ {
+		generation, err := cb.Allow()
+		assert.NoError(t, err)
+		if i%2 == 0 {
+			cb.Success(generation)
+		} else {
+			cb.Failure(generation)
+		}
+	}
+	assert.Equal(t, CircuitOpen, cb.State())
+	_, err := cb.Allow()
+	assert.Equal(t, ErrCircuitOpen, err)
+
+	time.Sleep(cb.OpenTimeout)
+	generation, err := cb.Allow()
+	assert.NoError(t, err)
+	assert.Equal(t, CircuitHalfOpen, cb.State())
+	_, err = cb.Allow()
+	assert.Equal(t, ErrCircuitOpen, err, "only one probe is allowed")
+	cb.Failure(generation)
+	assert.Equal(t, CircuitOpen, cb.State())
+
+	time.Sleep(cb.OpenTimeout)
+	generation, err = cb.Allow()
+	assert.NoError(t, err)
+	cb.Success(generation)
+	assert.Equal(t, CircuitClosed, cb.State())
+
+	assert.Equal(t, []CircuitState{CircuitOpen, CircuitHalfOpen, CircuitOpen, CircuitHalfOpen, CircuitClosed}, transitions)
+}
+
+func TestCircuitBreakerStaleResult(t *testing.T) {
+	cb := NewCircuitBreaker()
+	cb.MinRequests = 1
+	cb.OpenTimeout = time.Millisecond
+
+	staleGeneration, err := cb.Allow() // is allowed while the circuit is closed
+	assert.NoError(t, err)
+
+	generation, _ := cb.Allow()
+	cb.Failure(generation)
+	assert.Equal(t, CircuitOpen, cb.State())
+
+	time.Sleep(cb.OpenTimeout)
+	probeGeneration, err := cb.Allow()
+	assert.NoError(t, err)
+	assert.Equal(t, CircuitHalfOpen, cb.State())
+
+	// the result of the stale request doesn't close the circuit
+	cb.Success(staleGeneration)
+	assert.Equal(t, CircuitHalfOpen, cb.State())
+	_, err = cb.Allow()
+	assert.Equal(t, ErrCircuitOpen, err, "the probe is still in flight")
+
+	cb.Success(probeGeneration)
+	assert.Equal(t, CircuitClosed, cb.State())
+}
+
+func TestCircuitBreakerProbe(t *testing.T) {
+	var isBackendUp, probeCount int32
+	transitions := make(chan string, 10)
+
+	cb := NewCircuitBreaker().Clone()
+	cb.Name = "backend-1"
+	cb.MinRequests = 1
+	cb.OpenTimeout = time.Millisecond
+	cb.OnStateChange = func(cb *CircuitBreaker, from, to CircuitState) {
+		transitions <- cb.Name + ": " + to.String()
+	}
+	cb.Probe = func() bool {
+		atomic.AddInt32(&probeCount, 1)
+		return atomic.LoadInt32(&isBackendUp) != 0
+	}
+	defer cb.Close()
+
+	generation, _ := cb.Allow()
+	cb.Failure(generation)
+	assert.Equal(t, "backend-1: open", <-transitions)
+
+	// failed probes keep the circuit open without any requests
+	assert.True(t, waitFor(func() bool { return atomic.LoadInt32(&probeCount) >= 3 }))
+	assert.True(t, cb.IsOpen())
+	_, err := cb.Allow()
+	assert.Equal(t, ErrCircuitOpen, err)
+
+	atomic.StoreInt32(&isBackendUp, 1)
+	select {
+	case transition := <-transitions:
+		assert.Equal(t, "backend-1: half-open", transition)
+	case <-time.After(time.Second):
+		t.Fatal("the circuit didn't become half-open after a successful probe")
+	}
+	assert.False(t, cb.IsOpen())
+	generation, err = cb.Allow()
+	assert.NoError(t, err)
+	cb.Success(generation)
+	assert.Equal(t, CircuitClosed, cb.State())
+}
+
+func TestSocketClientCircuitBreakerPerClient(t *testing.T) {
+	cb := NewCircuitBreaker()
+	cb.MinRequests = 1
+	cb.OpenTimeout = time.Hour
+	cfg := Config{
+		Address:        "fasthttp:native:unixpacket:/nonexistent.sock",
+		CircuitBreaker: cb,
+	}
+
+	client1, err := NewSocketClient(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer client1.CircuitBreaker.Close()
+	client2, err := NewSocketClient(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer client2.CircuitBreaker.Close()
+
+	assert.False(t, client1.CircuitBreaker == cb)
+	assert.False(t, client1.CircuitBreaker == client2.CircuitBreaker)
+	assert.Nil(t, cb.Probe)
+
+	generation, _ := client1.CircuitBreaker.Allow()
+	client1.CircuitBreaker.Failure(generation)
+	assert.Equal(t, CircuitOpen, client1.CircuitBreaker.State())
+	assert.Equal(t, CircuitClosed, client2.CircuitBreaker.State())
+	assert.Equal(t, CircuitClosed, cb.State())
+}
@@ -2,10 +2,107 @@ package fasthttpsocket
 
 import (
 	"os"
+	"time"
+
+	"github.com/trafficstars/fasthttp"
 )
 
 type Config struct {
-	Address               string
+	Address string
+
+	// UnixSocketPermissions (if set) are applied to the file of a unix socket
+	// by SocketServer (otherwise they are set by umask)
 	UnixSocketPermissions os.FileMode
-	Logger                Logger
+
+	Logger Logger
+
+	// Dial (if set) is used by SocketClient instead of net.Dial. Without Dial
+	// a "unixgram" connection is bound to a temporary local address, so the
+	// server could respond to it.
+	Dial DialFunc
+
+	// FastCGIParams are additional params sent by SocketClient with every
+	// request if the "fastcgi" data model is used (for example: SCRIPT_FILENAME).
+	FastCGIParams map[string]string
+
+	// UserValueKeys are keys of fasthttp.RequestCtx user values which are
+	// transferred with requests. SocketServer restores only the listed keys.
+	// Values should be strings, []byte, fmt.Stringer, encoding.TextMarshaler,
+	// int, int64, uint64 or bool. They are restored as strings. Only the
+	// "fasthttp" and "go/net/http" data models support user values.
+	UserValueKeys []string
+
+	// ResponseUserValueKeys are the same as UserValueKeys, but are transferred
+	// back with responses (from SocketServer to SocketClient).
+	ResponseUserValueKeys []string
+
+	// ReconnectMinDelay and ReconnectMaxDelay are bounds of the exponential
+	// backoff used by SocketClient to reconnect broken connections in the
+	// background.
+	ReconnectMinDelay time.Duration
+	ReconnectMaxDelay time.Duration
+
+	// RetryPolicy is used by SocketClient.SendAndReceive and
+	// BalancedSocketClient.SendAndReceive. Failed requests are not repeated if
+	// it's nil (&DefaultRetryPolicy could be used to enable retries).
+	RetryPolicy *RetryPolicy
+
+	// CircuitBreaker (if set) is used by SocketClient to fail fast while the
+	// backend is down (see NewCircuitBreaker). It's a template: every
+	// SocketClient (and every backend of BalancedSocketClient) uses its own
+	// clone of it, so its state is not shared.
+	CircuitBreaker *CircuitBreaker
+
+	// HedgeDelay (if positive) enables hedged requests: if there's no response
+	// to a GET or HEAD request after HedgeDelay then the same request is sent
+	// via another connection (another backend for BalancedSocketClient) and
+	// the first successful response is used. The other request is canceled
+	// by breaking its connection (it's reconnected in the background).
+	HedgeDelay time.Duration
+
+	// AsyncQueueSize is the maximal amount of queued requests of SendAsync and
+	// SendFuture (1024 by default). They are sent by one worker goroutine per
+	// connection.
+	AsyncQueueSize int
+
+	// ConnConcurrency is the maximal amount of requests of a connection which
+	// are handled by SocketServer at once (8 by default). It's used only with
+	// the "fasthttp" and "go/net/http" data models, requests of other data
+	// models are handled one by one. It matters only for pipelined requests
+	// (SocketClient.SendBatch): other requests of a connection are sent one
+	// by one.
+	ConnConcurrency int
+
+	// Timeouts of SocketServer (see the fields of SocketServer). They are not
+	// used by default.
+	ReadTimeout            time.Duration
+	WriteTimeout           time.Duration
+	IdleTimeout            time.Duration
+	HandlerTimeout         time.Duration
+	HandlerTimeoutResponse fasthttp.RequestHandler // DefaultHandlerTimeoutResponse by default
+
+	// Limits of SocketServer (see the fields of SocketServer). There are no
+	// limits by default.
+	MaxConns              int
+	MaxConcurrentRequests int
+	MaxQueueWait          time.Duration
+	OverloadResponse      fasthttp.RequestHandler // DefaultOverloadResponse by default
+
+	// Addresses are used by BalancedSocketClient instead of Address. Each
+	// address has the same syntax as Address.
+	Addresses         []string
+	BalancingStrategy BalancingStrategy
+	BalancingKeyFunc  BalancingKeyFunc // is required for BalancingConsistentHash
+
+	// A backend of BalancedSocketClient is excluded from balancing for
+	// EjectTimeout after EjectFailures consecutive failures.
+	EjectFailures int
+	EjectTimeout  time.Duration
+
+	// DiscoveryAddress (if set) makes BalancedSocketClient add and remove
+	// backends automatically for socket files matching a glob pattern, for
+	// example: "raw:native:unixpacket:/run/app/worker-*.sock". The files are
+	// checked every DiscoveryInterval (one second by default).
+	DiscoveryAddress  string
+	DiscoveryInterval time.Duration
 }
@@ -0,0 +1,224 @@
+package fasthttpsocket
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/trafficstars/fasthttp"
+)
+
+const (
+	connInfoUserValueKey = `fasthttpsocket.ConnInfo`
+)
+
+var (
+	ErrNoOriginalConn = errors.New(`[fasthttp-socket] the original connection of the request is not available`)
+)
+
+// ConnInfo describes the connection a request was originally received on (on
+// the SocketClient side). It is transferred only by the "fasthttp" and
+// "go/net/http" data models.
+type ConnInfo struct {
+	RemoteNetwork string
+	RemoteAddr    string
+	LocalNetwork  string
+	LocalAddr     string
+	IsTLS         bool
+	ConnID        uint64
+	ConnTime      time.Time
+}
+
+// GetConnInfo returns the original connection info of a request handled by
+// SocketServer. RemoteAddr, LocalAddr, RemoteIP and IsTLS of "ctx" are already
+// restored, this function is required only to get ConnID and ConnTime.
+//
+// Returns nil if the data model doesn't transfer the connection info.
+func GetConnInfo(ctx *fasthttp.RequestCtx) *ConnInfo {
+	info, _ := ctx.UserValue(connInfoUserValueKey).(*ConnInfo)
+	return info
+}
+
+type connInfoCarrier interface {
+	connInfo() *ConnInfo
+}
+
+func (info *ConnInfo) Reset() {
+	*info = ConnInfo{}
+}
+
+func (info *ConnInfo) fill(ctx *fasthttp.RequestCtx) {
+	if origInfo := GetConnInfo(ctx); origInfo != nil { // "ctx" is already a proxied request (or a copy of a request)
+		*info = *origInfo
+		return
+	}
+	info.Reset()
+	if addr := ctx.RemoteAddr(); addr != nil {
+		info.RemoteNetwork = addr.Network()
+		info.RemoteAddr = addr.String()
+	}
+	if addr := ctx.LocalAddr(); addr != nil {
+		info.LocalNetwork = addr.Network()
+		info.LocalAddr = addr.String()
+	}
+	info.IsTLS = ctx.IsTLS()
+	info.ConnID = ctx.ConnID()
+	info.ConnTime = ctx.ConnTime()
+}
+
+// apply makes "ctx" look like it was received on the original connection.
+// "logger" is used by ctx.Logger().
+func (info *ConnInfo) apply(ctx *fasthttp.RequestCtx, logger Logger) {
+	conn := &connInfoConn{
+		info:       *info,
+		localAddr:  newConnInfoAddr(info.LocalNetwork, info.LocalAddr),
+		remoteAddr: newConnInfoAddr(info.RemoteNetwork, info.RemoteAddr),
+	}
+	if info.IsTLS {
+		ctx.Init2(&connInfoTLSConn{conn}, fasthttpLogger{logger}, false)
+	} else {
+		ctx.Init2(conn, fasthttpLogger{logger}, false)
+	}
+	ctx.SetUserValue(connInfoUserValueKey, &conn.info)
+}
+
+func (info *ConnInfo) marshalTo(dst []byte) []byte {
+	dst = appendString(dst, info.RemoteNetwork)
+	dst = appendString(dst, info.RemoteAddr)
+	dst = appendString(dst, info.LocalNetwork)
+	dst = appendString(dst, info.LocalAddr)
+	if info.IsTLS {
+		dst = appendUvarint(dst, 1)
+	} else {
+		dst = appendUvarint(dst, 0)
+	}
+	dst = appendUvarint(dst, info.ConnID)
+	var connTime int64
+	if !info.ConnTime.IsZero() {
+		connTime = info.ConnTime.UnixNano()
+	}
+	return appendUvarint(dst, uint64(connTime))
+}
+
+// connInfoFields are fields of a ConnInfo encoded by marshalTo
+var connInfoFields = []streamFieldCopier{
+	copyBytes, copyBytes, copyBytes, copyBytes, // networks and addresses
+	copyUvarint, copyUvarint, copyUvarint, // IsTLS, ConnID and ConnTime
+}
+
+// copyConnInfo copies a ConnInfo encoded by marshalTo from a stream
+func copyConnInfo(dst []byte, r *bufio.Reader) ([]byte, error) {
+	return readMessage(dst, r, connInfoFields)
+}
+
+func (info *ConnInfo) unmarshalFrom(src []byte) (_ []byte, err error) {
+	if info.RemoteNetwork, src, err = readString(src); err != nil {
+		return src, err
+	}
+	if info.RemoteAddr, src, err = readString(src); err != nil {
+		return src, err
+	}
+	if info.LocalNetwork, src, err = readString(src); err != nil {
+		return src, err
+	}
+	if info.LocalAddr, src, err = readString(src); err != nil {
+		return src, err
+	}
+	var isTLS, connTime uint64
+	if isTLS, src, err = readUvarint(src); err != nil {
+		return src, err
+	}
+	info.IsTLS = isTLS != 0
+	if info.ConnID, src, err = readUvarint(src); err != nil {
+		return src, err
+	}
+	if connTime, src, err = readUvarint(src); err != nil {
+		return src, err
+	}
+	info.ConnTime = time.Time{}
+	if connTime != 0 {
+		info.ConnTime = time.Unix(0, int64(connTime))
+	}
+	return src, nil
+}
+
+type connInfoAddr struct {
+	network string
+	address string
+}
+
+func (addr *connInfoAddr) Network() string {
+	return addr.network
+}
+
+func (addr *connInfoAddr) String() string {
+	return addr.address
+}
+
+func newConnInfoAddr(network, address string) net.Addr {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		// fasthttp.RequestCtx.RemoteIP() works only with *net.TCPAddr
+		if addr, err := net.ResolveTCPAddr(network, address); err == nil {
+			return addr
+		}
+	}
+	return &connInfoAddr{network: network, address: address}
+}
+
+// connInfoConn is a fake connection which is used only to provide addresses
+// to fasthttp.RequestCtx (the same as fasthttp does in RequestCtx.Init). The
+// original connection is on the SocketClient side, so reading, writing and
+// closing return ErrNoOriginalConn.
+type connInfoConn struct {
+	info       ConnInfo
+	localAddr  net.Addr
+	remoteAddr net.Addr
+}
+
+func (conn *connInfoConn) Read(b []byte) (int, error) {
+	return 0, ErrNoOriginalConn
+}
+
+func (conn *connInfoConn) Write(b []byte) (int, error) {
+	return 0, ErrNoOriginalConn
+}
+
+func (conn *connInfoConn) Close() error {
+	return ErrNoOriginalConn
+}
+
+func (conn *connInfoConn) SetDeadline(t time.Time) error {
+	return ErrNoOriginalConn
+}
+
+func (conn *connInfoConn) SetReadDeadline(t time.Time) error {
+	return ErrNoOriginalConn
+}
+
+func (conn *connInfoConn) SetWriteDeadline(t time.Time) error {
+	return ErrNoOriginalConn
+}
+
+func (conn *connInfoConn) LocalAddr() net.Addr {
+	return conn.localAddr
+}
+
+func (conn *connInfoConn) RemoteAddr() net.Addr {
+	return conn.remoteAddr
+}
+
+// connInfoTLSConn makes fasthttp.RequestCtx.IsTLS() return true
+type connInfoTLSConn struct {
+	*connInfoConn
+}
+
+func (conn *connInfoTLSConn) ConnectionState() tls.ConnectionState {
+	return tls.ConnectionState{}
+}
+
+func (conn *connInfoTLSConn) Handshake() error {
+	return nil
+}
@@ -0,0 +1,82 @@
+package fasthttpsocket
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trafficstars/fasthttp"
+)
+
+type testPrintLogger struct {
+	testRecordingLogger
+	prints []interface{}
+}
+
+func (l *testPrintLogger) Print(args ...interface{}) {
+	l.prints = append(l.prints, args...)
+}
+
+func TestConnInfo(t *testing.T) {
+	for _, isTLS := range []bool{false, true} {
+		var req fasthttp.Request
+		clientCtx := &fasthttp.RequestCtx{}
+		clientCtx.Init(&req, &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 5678}, nil)
+
+		var info ConnInfo
+		info.fill(clientCtx)
+		info.IsTLS = isTLS
+		assert.Equal(t, "tcp", info.RemoteNetwork)
+		assert.Equal(t, "1.2.3.4:5678", info.RemoteAddr)
+		assert.Equal(t, clientCtx.ConnID(), info.ConnID)
+
+		var restoredInfo ConnInfo
+		rest, err := restoredInfo.unmarshalFrom(info.marshalTo([]byte{}))
+		assert.NoError(t, err)
+		assert.Empty(t, rest)
+		assert.Equal(t, info.ConnTime.UnixNano(), restoredInfo.ConnTime.UnixNano())
+		restoredInfo.ConnTime = info.ConnTime
+		assert.Equal(t, info, restoredInfo)
+
+		logger := &testPrintLogger{}
+		serverCtx := &fasthttp.RequestCtx{}
+		restoredInfo.apply(serverCtx, logger)
+
+		assert.Equal(t, "1.2.3.4", serverCtx.RemoteIP().String())
+		assert.Equal(t, "1.2.3.4:5678", serverCtx.RemoteAddr().String())
+		assert.Equal(t, info.LocalAddr, serverCtx.LocalAddr().String())
+		assert.Equal(t, isTLS, serverCtx.IsTLS())
+		if connInfo := GetConnInfo(serverCtx); assert.NotNil(t, connInfo) {
+			assert.Equal(t, clientCtx.ConnID(), connInfo.ConnID)
+			assert.Equal(t, clientCtx.ConnTime().UnixNano(), connInfo.ConnTime.UnixNano())
+		}
+
+		// the connection info of a copy is the original one
+		var copyInfo ConnInfo
+		copyInfo.fill(serverCtx)
+		assert.Equal(t, clientCtx.ConnID(), copyInfo.ConnID)
+
+		serverCtx.Logger().Printf("message %d", 1)
+		if assert.Len(t, logger.prints, 1) {
+			assert.Contains(t, logger.prints[0], "message 1")
+		}
+
+		_, err = serverCtx.Conn().Write([]byte("data"))
+		assert.Equal(t, ErrNoOriginalConn, err)
+		assert.Equal(t, ErrNoOriginalConn, serverCtx.Conn().Close())
+		assert.Equal(t, ErrNoOriginalConn, serverCtx.Conn().SetDeadline(clientCtx.ConnTime()))
+	}
+
+	assert.Nil(t, GetConnInfo(&fasthttp.RequestCtx{}))
+}
+
+func TestConnInfoUnixAddr(t *testing.T) {
+	info := ConnInfo{
+		RemoteNetwork: "unix",
+		RemoteAddr:    "@",
+	}
+	ctx := &fasthttp.RequestCtx{}
+	info.apply(ctx, dummyLogger)
+	assert.Equal(t, "unix", ctx.RemoteAddr().Network())
+	assert.Equal(t, "@", ctx.RemoteAddr().String())
+}
@@ -0,0 +1,130 @@
+package fasthttpsocket
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+
+	"github.com/trafficstars/fasthttp"
+)
+
+type requestCtxContextKey struct{}
+
+// HandleRequesterFunc is an adapter to use a function as a HandleRequester
+type HandleRequesterFunc func(ctx *fasthttp.RequestCtx) error
+
+func (f HandleRequesterFunc) HandleRequest(ctx *fasthttp.RequestCtx) error {
+	return f(ctx)
+}
+
+// RequestHandler is an adapter to use a fasthttp.RequestHandler (for example
+// a router) as a HandleRequester
+type RequestHandler fasthttp.RequestHandler
+
+func (handler RequestHandler) HandleRequest(ctx *fasthttp.RequestCtx) error {
+	handler(ctx)
+	return nil
+}
+
+// NewSocketServerWithRequestHandler is the same as NewSocketServer, but
+// accepts a fasthttp.RequestHandler
+func NewSocketServerWithRequestHandler(handler fasthttp.RequestHandler, cfg Config) (*SocketServer, error) {
+	return NewSocketServer(RequestHandler(handler), cfg)
+}
+
+// NetHttpHandler is an adapter to use a http.Handler as a HandleRequester.
+// The request of fasthttp.RequestCtx is converted to *http.Request (including
+// RemoteAddr and TLS restored by SocketServer) and the response is written
+// back into the fasthttp.RequestCtx. The context of *http.Request is derived
+// from HandlerContext (so it's done on HandlerTimeout). A request which can't
+// be converted is answered with "400 Bad Request".
+type NetHttpHandler struct {
+	Handler http.Handler
+
+	codec *ClientCodecNetHttp
+}
+
+func NewNetHttpHandler(handler http.Handler) *NetHttpHandler {
+	return &NetHttpHandler{
+		Handler: handler,
+		codec:   newClientCodecNetHttp(),
+	}
+}
+
+// NewSocketServerWithNetHttpHandler is the same as NewSocketServer, but
+// accepts a http.Handler
+func NewSocketServerWithNetHttpHandler(handler http.Handler, cfg Config) (*SocketServer, error) {
+	return NewSocketServer(NewNetHttpHandler(handler), cfg)
+}
+
+// NetHttpRequestCtx returns the fasthttp.RequestCtx of a request passed to a
+// http.Handler by NetHttpHandler (for example, to use GetConnInfo). Returns
+// nil for other requests.
+func NetHttpRequestCtx(req *http.Request) *fasthttp.RequestCtx {
+	ctx, _ := req.Context().Value(requestCtxContextKey{}).(*fasthttp.RequestCtx)
+	return ctx
+}
+
+func (handler *NetHttpHandler) HandleRequest(ctx *fasthttp.RequestCtx) error {
+	request := handler.codec.GetRequest().(*modelNetHttpRequest)
+	defer request.Release()
+	if err := handler.codec.Encode(request, ctx); err != nil {
+		return err
+	}
+	req, err := request.ToRequest()
+	if err != nil {
+		ctx.Error(fasthttp.StatusMessage(fasthttp.StatusBadRequest), fasthttp.StatusBadRequest)
+		return nil
+	}
+	if ctx.IsTLS() {
+		req.TLS = &tls.ConnectionState{}
+	}
+	req = req.WithContext(context.WithValue(HandlerContext(ctx), requestCtxContextKey{}, ctx))
+
+	response := handler.codec.GetResponse().(*modelNetHttpResponse)
+	defer response.Release()
+	w := &netHttpResponseWriter{
+		response: response,
+		header:   http.Header{},
+	}
+	handler.Handler.ServeHTTP(w, req)
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	return handler.codec.Decode(ctx, response)
+}
+
+// netHttpResponseWriter is a http.ResponseWriter which writes into a
+// modelNetHttpResponse
+type netHttpResponseWriter struct {
+	response    *modelNetHttpResponse
+	header      http.Header
+	wroteHeader bool
+}
+
+func (w *netHttpResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *netHttpResponseWriter) WriteHeader(statusCode int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.response.StatusCode = statusCode
+	for key, values := range w.header { // changes after WriteHeader are ignored (as in net/http)
+		w.response.Header[key] = append(w.response.Header[key][:0], values...)
+	}
+}
+
+func (w *netHttpResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.header.Get("Content-Type") == "" {
+			w.header.Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	w.response.Body = append(w.response.Body, b...)
+	return len(b), nil
+}
@@ -0,0 +1,65 @@
+package fasthttpsocket
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trafficstars/fasthttp"
+)
+
+func TestNetHttpHandler(t *testing.T) {
+	address := "fasthttp:native:unixpacket:" + filepath.Join(t.TempDir(), "net-http.sock")
+	srv, err := NewSocketServerWithNetHttpHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NotNil(t, NetHttpRequestCtx(r))
+		if deadline, ok := r.Context().Deadline(); ok {
+			w.Header().Set("X-Deadline", deadline.Sub(time.Now()).Round(time.Minute).String())
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("path " + r.URL.Path))
+	}), Config{
+		Address:               address,
+		UnixSocketPermissions: 0700,
+		HandlerTimeout:        time.Hour,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.NoError(t, srv.Start()) {
+		return
+	}
+	defer srv.Stop()
+
+	client, err := NewSocketClient(Config{Address: address})
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.NoError(t, client.Start(1)) {
+		return
+	}
+	defer client.Close()
+
+	// the context of the request is done on HandlerTimeout
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/path")
+	assert.NoError(t, client.SendAndReceive(ctx))
+	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	assert.Equal(t, "path /path", string(ctx.Response.Body()))
+	assert.Equal(t, "1h0m0s", string(ctx.Response.Header.Peek("X-Deadline")))
+
+	// a request which can't be converted to *http.Request
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetRequestURI("invalid")
+	assert.NoError(t, client.SendAndReceive(ctx))
+	assert.Equal(t, fasthttp.StatusBadRequest, ctx.Response.StatusCode())
+
+	// the connection is still usable
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/next")
+	assert.NoError(t, client.SendAndReceive(ctx))
+	assert.Equal(t, "path /next", string(ctx.Response.Body()))
+	assert.Len(t, client.clientConns, 1)
+	assert.True(t, client.clientConns[0].IsHealthy())
+}
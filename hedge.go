@@ -0,0 +1,255 @@
+package fasthttpsocket
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/trafficstars/fasthttp"
+	"github.com/trafficstars/spinlock"
+)
+
+var (
+	errHedgeCanceled = errors.New(`[fasthttp-socket-client] the hedged request is canceled (another one succeeded)`)
+)
+
+var (
+	hedgeCtxPool = sync.Pool{
+		New: func() interface{} {
+			return &fasthttp.RequestCtx{}
+		},
+	}
+
+	// hedgeAttempts maps requests being sent by hedgedSend to their attempts
+	// (user values are visible to the caller and could be transferred)
+	hedgeAttempts sync.Map
+)
+
+type hedgeResult struct {
+	ctx    *fasthttp.RequestCtx // is nil for the primary request
+	isSent bool
+	err    error
+}
+
+// hedgeAttempt is stored in user values of a request sent by hedgedSend. It
+// allows to abort the request if another one succeeded, and it guards the
+// request and the response of the primary request while the request is
+// copied for the secondary one.
+type hedgeAttempt struct {
+	spinlock.Locker
+
+	cancelOnce sync.Once
+	cancelChan chan struct{}
+}
+
+func newHedgeAttempt() *hedgeAttempt {
+	return &hedgeAttempt{
+		cancelChan: make(chan struct{}),
+	}
+}
+
+// getHedgeAttempt returns nil if "ctx" is not sent by hedgedSend
+func getHedgeAttempt(ctx *fasthttp.RequestCtx) *hedgeAttempt {
+	v, _ := hedgeAttempts.Load(ctx)
+	attempt, _ := v.(*hedgeAttempt)
+	return attempt
+}
+
+// lockDo calls "fn" with locked "attempt" (if any)
+func (attempt *hedgeAttempt) lockDo(fn func()) {
+	if attempt == nil {
+		fn()
+		return
+	}
+	attempt.LockDo(fn)
+}
+
+func (attempt *hedgeAttempt) cancel() {
+	attempt.cancelOnce.Do(func() {
+		close(attempt.cancelChan)
+	})
+}
+
+func (attempt *hedgeAttempt) isCanceled() bool {
+	if attempt == nil {
+		return false
+	}
+	select {
+	case <-attempt.cancelChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// abortOnCancel sets a past deadline to "conn" (it interrupts reading and
+// writing) if the attempt is canceled before the returned function is
+// called. The function returns true if "conn" was aborted.
+func (attempt *hedgeAttempt) abortOnCancel(conn net.Conn) (stop func() bool) {
+	if attempt == nil || conn == nil {
+		return func() bool { return false }
+	}
+	doneChan := make(chan struct{})
+	isAbortedChan := make(chan bool, 1)
+	go func() {
+		select {
+		case <-attempt.cancelChan:
+			_ = conn.SetDeadline(time.Now())
+			isAbortedChan <- true
+		case <-doneChan:
+			isAbortedChan <- false
+		}
+	}()
+	return func() bool {
+		close(doneChan)
+		return <-isAbortedChan
+	}
+}
+
+// isHedgeableMethod returns true for read-only methods: only such requests
+// may be sent twice.
+func isHedgeableMethod(method []byte) bool {
+	switch string(method) {
+	case "GET", "HEAD":
+		return true
+	}
+	return false
+}
+
+// isHedgeInternalUserValue returns true for user values which are specific
+// to a copy of a request
+func isHedgeInternalUserValue(key []byte) bool {
+	switch string(key) {
+	case connInfoUserValueKey:
+		return true
+	}
+	return false
+}
+
+// acquireHedgeCtx returns a copy of "ctx" (the request, the connection info
+// and user values) which may be sent independently of "ctx".
+func acquireHedgeCtx(ctx *fasthttp.RequestCtx) *fasthttp.RequestCtx {
+	hedgeCtx := hedgeCtxPool.Get().(*fasthttp.RequestCtx)
+	var info ConnInfo
+	info.fill(ctx)
+	info.apply(hedgeCtx, dummyLogger) // the copy is only sent, it's not handled
+	ctx.Request.CopyTo(&hedgeCtx.Request)
+	ctx.VisitUserValues(func(key []byte, value interface{}) {
+		if !isHedgeInternalUserValue(key) {
+			hedgeCtx.SetUserValue(string(key), value)
+		}
+	})
+	return hedgeCtx
+}
+
+func releaseHedgeCtx(hedgeCtx *fasthttp.RequestCtx) {
+	hedgeCtx.Request.Reset()
+	hedgeCtx.Response.Reset()
+	hedgeCtx.ResetUserValues()
+	hedgeAttempts.Delete(hedgeCtx)
+	hedgeCtxPool.Put(hedgeCtx)
+}
+
+// copyHedgeResult copies the response (and user values set while receiving
+// it) of "hedgeCtx" into "ctx".
+func copyHedgeResult(ctx, hedgeCtx *fasthttp.RequestCtx) {
+	hedgeCtx.Response.CopyTo(&ctx.Response)
+	hedgeCtx.VisitUserValues(func(key []byte, value interface{}) {
+		if !isHedgeInternalUserValue(key) {
+			ctx.SetUserValue(string(key), value)
+		}
+	})
+}
+
+// hedgedSend sends the request of "ctx" via "primary". If there's no
+// response after "delay" then a copy of the request is sent via "secondary"
+// and the first successful response is used. The other request is canceled:
+// SocketClient aborts it by breaking its connection (a request which is
+// already being transmitted can't be aborted otherwise), so the connection
+// is reconnected instead of being busy until the response arrives.
+//
+// Requests with non read-only methods (see isHedgeableMethod) are just sent
+// via "primary".
+func hedgedSend(ctx *fasthttp.RequestCtx, delay time.Duration, primary, secondary sendFunc) (isSent bool, err error) {
+	if delay <= 0 || !isHedgeableMethod(ctx.Request.Header.Method()) {
+		return primary(ctx)
+	}
+
+	// the primary request is sent without copying, the attempt is removed
+	// only after the primary request is finished
+	primaryAttempt := newHedgeAttempt()
+	hedgeAttempts.Store(ctx, primaryAttempt)
+	defer hedgeAttempts.Delete(ctx)
+
+	results := make(chan hedgeResult, 2)
+	go func() {
+		isSent, err := primary(ctx)
+		results <- hedgeResult{isSent: isSent, err: err}
+	}()
+	pending := 1
+
+	var secondaryAttempt *hedgeAttempt
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	timerChan := timer.C
+
+	for {
+		select {
+		case <-timerChan:
+			timerChan = nil
+
+			// the primary request may be encoded or its response may be
+			// decoded right now
+			var hedgeCtx *fasthttp.RequestCtx
+			primaryAttempt.lockDo(func() {
+				hedgeCtx = acquireHedgeCtx(ctx)
+			})
+			secondaryAttempt = newHedgeAttempt()
+			hedgeAttempts.Store(hedgeCtx, secondaryAttempt)
+
+			pending++
+			go func() {
+				isSent, err := secondary(hedgeCtx)
+				results <- hedgeResult{ctx: hedgeCtx, isSent: isSent, err: err}
+			}()
+
+		case result := <-results:
+			pending--
+			isSent = isSent || result.isSent
+			err = result.err
+
+			if err != nil {
+				if result.ctx != nil {
+					releaseHedgeCtx(result.ctx)
+				}
+				// the secondary request is not sent if the primary one failed
+				// fast: it's a task of RetryPolicy to repeat failed requests.
+				if pending == 0 {
+					return
+				}
+				continue
+			}
+
+			if result.ctx == nil { // the primary request succeeded
+				if pending > 0 {
+					secondaryAttempt.cancel()
+					go func() {
+						releaseHedgeCtx((<-results).ctx)
+					}()
+				}
+				return isSent, nil
+			}
+
+			// the secondary request succeeded, the primary one writes into
+			// "ctx", so it's waited for after canceling
+			if pending > 0 {
+				primaryAttempt.cancel()
+				<-results
+			}
+			copyHedgeResult(ctx, result.ctx)
+			releaseHedgeCtx(result.ctx)
+			return isSent, nil
+		}
+	}
+}
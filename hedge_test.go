@@ -0,0 +1,150 @@
+package fasthttpsocket
+
+import (
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trafficstars/fasthttp"
+)
+
+func TestHedgedSend(t *testing.T) {
+	respond := func(body string) sendFunc {
+		return func(ctx *fasthttp.RequestCtx) (bool, error) {
+			ctx.SetBodyString(body)
+			return true, nil
+		}
+	}
+	var canceledCount int32
+	waitForCancel := func(ctx *fasthttp.RequestCtx) (bool, error) {
+		<-getHedgeAttempt(ctx).cancelChan
+		atomic.AddInt32(&canceledCount, 1)
+		return true, errHedgeCanceled
+	}
+	notCalled := func(ctx *fasthttp.RequestCtx) (bool, error) {
+		t.Error("the secondary request shouldn't be sent")
+		return false, nil
+	}
+	userValueKeys := func(ctx *fasthttp.RequestCtx) (r []string) {
+		ctx.VisitUserValues(func(key []byte, value interface{}) {
+			r = append(r, string(key))
+		})
+		return
+	}
+
+	// a fast response: the request is sent as is (without copying)
+	ctx := &fasthttp.RequestCtx{}
+	isSent, err := hedgedSend(ctx, time.Hour, func(sentCtx *fasthttp.RequestCtx) (bool, error) {
+		assert.True(t, sentCtx == ctx)
+		return respond("primary")(sentCtx)
+	}, notCalled)
+	assert.True(t, isSent)
+	assert.NoError(t, err)
+	assert.Equal(t, "primary", string(ctx.Response.Body()))
+	assert.Nil(t, getHedgeAttempt(ctx))
+	assert.Empty(t, userValueKeys(ctx), "hedging leaves user values")
+
+	// the secondary request wins: the primary one is canceled and waited for
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/hedged")
+	ctx.SetUserValue("key", "value")
+	isSent, err = hedgedSend(ctx, time.Millisecond, waitForCancel, func(hedgeCtx *fasthttp.RequestCtx) (bool, error) {
+		assert.False(t, hedgeCtx == ctx)
+		assert.Equal(t, "/hedged", string(hedgeCtx.Request.URI().Path()))
+		assert.Equal(t, "value", hedgeCtx.UserValue("key"))
+		hedgeCtx.SetUserValue("response-key", "response-value")
+		return respond("secondary")(hedgeCtx)
+	})
+	assert.True(t, isSent)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&canceledCount))
+	assert.Equal(t, "secondary", string(ctx.Response.Body()))
+	assert.Equal(t, "response-value", ctx.UserValue("response-key"))
+	assert.Nil(t, getHedgeAttempt(ctx))
+	assert.Equal(t, []string{"key", "response-key"}, userValueKeys(ctx))
+
+	// the primary request wins after the secondary one is sent: the secondary
+	// one is canceled
+	ctx = &fasthttp.RequestCtx{}
+	secondarySent := make(chan struct{})
+	isSent, err = hedgedSend(ctx, time.Millisecond, func(ctx *fasthttp.RequestCtx) (bool, error) {
+		<-secondarySent
+		return respond("primary")(ctx)
+	}, func(hedgeCtx *fasthttp.RequestCtx) (bool, error) {
+		close(secondarySent)
+		return waitForCancel(hedgeCtx)
+	})
+	assert.True(t, isSent)
+	assert.NoError(t, err)
+	assert.Equal(t, "primary", string(ctx.Response.Body()))
+	assert.True(t, waitFor(func() bool { return atomic.LoadInt32(&canceledCount) == 2 }))
+
+	// a fast failure is returned without sending the secondary request
+	ctx = &fasthttp.RequestCtx{}
+	isSent, err = hedgedSend(ctx, 10*time.Millisecond, func(ctx *fasthttp.RequestCtx) (bool, error) {
+		return false, ErrBusy
+	}, notCalled)
+	assert.False(t, isSent)
+	assert.Equal(t, ErrBusy, err)
+
+	// a POST is not hedged
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod("POST")
+	isSent, err = hedgedSend(ctx, time.Nanosecond, func(ctx *fasthttp.RequestCtx) (bool, error) {
+		assert.Nil(t, getHedgeAttempt(ctx))
+		time.Sleep(time.Millisecond)
+		return respond("primary")(ctx)
+	}, notCalled)
+	assert.True(t, isSent)
+	assert.NoError(t, err)
+	assert.Equal(t, "primary", string(ctx.Response.Body()))
+}
+
+func TestSocketClientHedgeDelay(t *testing.T) {
+	address := "fasthttp:native:unixpacket:" + filepath.Join(t.TempDir(), "hedge.sock")
+	var requestCount int32
+	srv := startTestServer(t, address, func(ctx *fasthttp.RequestCtx) {
+		if atomic.AddInt32(&requestCount, 1) == 1 {
+			time.Sleep(300 * time.Millisecond)
+		}
+		ctx.SetBodyString("ok")
+	})
+	defer srv.Stop()
+
+	var dialCount int32
+	client, err := NewSocketClient(Config{
+		Address:           address,
+		HedgeDelay:        10 * time.Millisecond,
+		ReconnectMinDelay: time.Millisecond,
+		Dial: func(network, address string) (net.Conn, error) {
+			atomic.AddInt32(&dialCount, 1)
+			return net.Dial(network, address)
+		},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.NoError(t, client.Start(2)) {
+		return
+	}
+	defer client.Close()
+
+	startTime := time.Now()
+	ctx := &fasthttp.RequestCtx{}
+	assert.NoError(t, client.SendAndReceive(ctx))
+	assert.True(t, time.Since(startTime) < 200*time.Millisecond, "the hedged request is answered first")
+	assert.Equal(t, "ok", string(ctx.Response.Body()))
+
+	// the connection of the slow request is aborted (and reconnected) instead
+	// of being busy until the response arrives
+	assert.True(t, waitFor(func() bool { return atomic.LoadInt32(&dialCount) == 3 }))
+	assert.True(t, waitFor(func() (r bool) {
+		client.LockDo(func() {
+			r = client.clientConns[0].IsHealthy() && client.clientConns[1].IsHealthy()
+		})
+		return
+	}))
+}
@@ -1,5 +1,9 @@
 package fasthttpsocket
 
+import (
+	"fmt"
+)
+
 type Logger interface {
 	Print(args ...interface{})
 	Errorf(format string, args ...interface{})
@@ -13,3 +17,12 @@ func (l *DummyLogger) Errorf(format string, args ...interface{}) {}
 var (
 	dummyLogger = &DummyLogger{}
 )
+
+// fasthttpLogger adapts Logger to fasthttp.Logger
+type fasthttpLogger struct {
+	Logger
+}
+
+func (l fasthttpLogger) Printf(format string, args ...interface{}) {
+	l.Logger.Print(fmt.Sprintf(format, args...))
+}
@@ -1,7 +1,6 @@
 package fasthttpsocket
 
 import (
-	"fmt"
 	"io"
 	"net"
 )
@@ -17,9 +16,15 @@ func NewMessanger(conn io.ReadWriter) Messanger {
 	case *net.UDPConn:
 		messanger = &UDPMessanger{conn}
 	case *net.UnixConn:
-		messanger = &UnixMessanger{conn}
+		if isUnixStream(conn) {
+			messanger = conn
+		} else {
+			messanger = &UnixMessanger{conn}
+		}
 	default:
-		panic(fmt.Errorf(`unknown type: %T`, conn))
+		// TCP connections, other stream connections (like net.Pipe) and
+		// connections which keep message boundaries by themselves
+		messanger = conn
 	}
 	return messanger
 }
@@ -30,6 +35,9 @@ type UnixMessanger struct {
 
 func (msg *UnixMessanger) Read(b []byte) (int, error) {
 	n, _, _, _, err := msg.ReadMsgUnix(b, nil)
+	if n < 0 { // is returned on errors, but io.Reader can't return it
+		n = 0
+	}
 	return n, err
 }
 
@@ -44,6 +52,9 @@ type UDPMessanger struct {
 
 func (msg *UDPMessanger) Read(b []byte) (int, error) {
 	n, _, _, _, err := msg.ReadMsgUDP(b, nil)
+	if n < 0 { // is returned on errors, but io.Reader can't return it
+		n = 0
+	}
 	return n, err
 }
 
@@ -51,3 +62,13 @@ func (msg *UDPMessanger) Write(b []byte) (int, error) {
 	n, _, err := msg.WriteMsgUDP(b, nil, nil)
 	return n, err
 }
+
+// isUnixStream returns true for "unix" (SOCK_STREAM) connections: they do not
+// preserve message boundaries, so they are used as is.
+func isUnixStream(conn *net.UnixConn) bool {
+	addr := conn.LocalAddr()
+	if addr == nil {
+		addr = conn.RemoteAddr()
+	}
+	return addr != nil && addr.Network() == "unix"
+}
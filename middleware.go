@@ -0,0 +1,88 @@
+package fasthttpsocket
+
+import (
+	"runtime/debug"
+	"time"
+
+	"github.com/trafficstars/fasthttp"
+)
+
+// Middleware wraps a HandleRequester (see SocketServer.Use)
+type Middleware func(next HandleRequester) HandleRequester
+
+// chainMiddlewares wraps "handleRequester", the first middleware is the
+// outermost one
+func chainMiddlewares(handleRequester HandleRequester, middlewares []Middleware) HandleRequester {
+	for idx := len(middlewares) - 1; idx >= 0; idx-- {
+		handleRequester = middlewares[idx](handleRequester)
+	}
+	return handleRequester
+}
+
+// handlePanic logs a recovered panic of a handler (with a stack trace) and
+// replaces the response with "500 Internal Server Error"
+func handlePanic(logger Logger, ctx *fasthttp.RequestCtx, r interface{}) {
+	logger.Errorf("[fasthttp-socket-handler] panic while handling %s %s: %v\n%s", ctx.Method(), ctx.RequestURI(), r, debug.Stack())
+	ctx.Response.Reset()
+	ctx.Error(fasthttp.StatusMessage(fasthttp.StatusInternalServerError), fasthttp.StatusInternalServerError)
+}
+
+// RecoveryMiddleware recovers panics of next HandleRequester-s, logs them
+// (with a stack trace) and responds with "500 Internal Server Error".
+func RecoveryMiddleware(logger Logger) Middleware {
+	return func(next HandleRequester) HandleRequester {
+		return HandleRequesterFunc(func(ctx *fasthttp.RequestCtx) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					handlePanic(logger, ctx, r)
+					err = nil
+				}
+			}()
+			return next.HandleRequest(ctx)
+		})
+	}
+}
+
+// AccessLogMiddleware logs every request: the remote address, the method,
+// the URI, the status code, the size of the response body and the duration.
+func AccessLogMiddleware(logger Logger) Middleware {
+	return func(next HandleRequester) HandleRequester {
+		return HandleRequesterFunc(func(ctx *fasthttp.RequestCtx) error {
+			startedAt := time.Now()
+			err := next.HandleRequest(ctx)
+			logger.Print(ctx.RemoteAddr(), ` "`, string(ctx.Method()), ` `, string(ctx.RequestURI()), `" `,
+				ctx.Response.StatusCode(), ` `, len(ctx.Response.Body()), ` `, time.Since(startedAt))
+			return err
+		})
+	}
+}
+
+// TimingMiddleware calls "observe" with the duration of handling of every
+// request (for example, to collect metrics).
+func TimingMiddleware(observe func(ctx *fasthttp.RequestCtx, duration time.Duration)) Middleware {
+	return func(next HandleRequester) HandleRequester {
+		return HandleRequesterFunc(func(ctx *fasthttp.RequestCtx) error {
+			startedAt := time.Now()
+			err := next.HandleRequest(ctx)
+			observe(ctx, time.Since(startedAt))
+			return err
+		})
+	}
+}
+
+// MaxRequestSizeMiddleware responds with "413 Request Entity Too Large" to
+// requests with a body larger than "maxSize" bytes (next HandleRequester-s
+// are not called). It doesn't limit memory usage: the check is done after
+// the whole request is received and decoded, so the size of a message is
+// limited only by the socket (a datagram) or by the peer (a stream).
+func MaxRequestSizeMiddleware(maxSize int) Middleware {
+	return func(next HandleRequester) HandleRequester {
+		return HandleRequesterFunc(func(ctx *fasthttp.RequestCtx) error {
+			if len(ctx.Request.Body()) > maxSize {
+				ctx.Error(fasthttp.StatusMessage(fasthttp.StatusRequestEntityTooLarge), fasthttp.StatusRequestEntityTooLarge)
+				return nil
+			}
+			return next.HandleRequest(ctx)
+		})
+	}
+}
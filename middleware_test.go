@@ -0,0 +1,118 @@
+package fasthttpsocket
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trafficstars/fasthttp"
+)
+
+func TestChainMiddlewares(t *testing.T) {
+	var calls []string
+	newMiddleware := func(name string) Middleware {
+		return func(next HandleRequester) HandleRequester {
+			return HandleRequesterFunc(func(ctx *fasthttp.RequestCtx) error {
+				calls = append(calls, name)
+				return next.HandleRequest(ctx)
+			})
+		}
+	}
+	handler := HandleRequesterFunc(func(ctx *fasthttp.RequestCtx) error {
+		calls = append(calls, "handler")
+		return nil
+	})
+
+	chain := chainMiddlewares(handler, []Middleware{newMiddleware("first"), newMiddleware("second")})
+	assert.NoError(t, chain.HandleRequest(&fasthttp.RequestCtx{}))
+	assert.Equal(t, []string{"first", "second", "handler"}, calls)
+}
+
+func newTestMiddlewareCtx(method, uri string) *fasthttp.RequestCtx {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Init(&fasthttp.Request{}, &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1234}, nil)
+	ctx.Request.Header.SetMethod(method)
+	ctx.Request.SetRequestURI(uri)
+	return ctx
+}
+
+func TestRecoveryMiddleware(t *testing.T) {
+	logger := &testRecordingLogger{}
+	handler := RecoveryMiddleware(logger)(HandleRequesterFunc(func(ctx *fasthttp.RequestCtx) error {
+		ctx.SetBodyString("partial response")
+		if string(ctx.Path()) == "/panic" {
+			panic("test panic")
+		}
+		return nil
+	}))
+
+	ctx := newTestMiddlewareCtx("GET", "/panic")
+	assert.NoError(t, handler.HandleRequest(ctx))
+	assert.Equal(t, fasthttp.StatusInternalServerError, ctx.Response.StatusCode())
+	assert.Equal(t, fasthttp.StatusMessage(fasthttp.StatusInternalServerError), string(ctx.Response.Body()))
+	if assert.Len(t, logger.Errors(), 1) {
+		assert.Contains(t, logger.Errors()[0], "panic while handling GET /panic: test panic")
+		assert.Contains(t, logger.Errors()[0], "middleware_test.go", "the stack trace is logged")
+	}
+
+	ctx = newTestMiddlewareCtx("GET", "/ok")
+	assert.NoError(t, handler.HandleRequest(ctx))
+	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	assert.Equal(t, "partial response", string(ctx.Response.Body()))
+	assert.Len(t, logger.Errors(), 1)
+}
+
+func TestAccessLogMiddleware(t *testing.T) {
+	logger := &testPrintLogger{}
+	handleErr := fmt.Errorf("test error")
+	handler := AccessLogMiddleware(logger)(HandleRequesterFunc(func(ctx *fasthttp.RequestCtx) error {
+		ctx.SetStatusCode(fasthttp.StatusCreated)
+		ctx.SetBodyString("12345")
+		return handleErr
+	}))
+
+	ctx := newTestMiddlewareCtx("POST", "/path?a=b")
+	assert.Equal(t, handleErr, handler.HandleRequest(ctx))
+	line := fmt.Sprint(logger.prints...)
+	assert.True(t, strings.HasPrefix(line, `10.0.0.1:1234 "POST /path?a=b" 201 5 `), line)
+}
+
+func TestTimingMiddleware(t *testing.T) {
+	var observedPath string
+	var observedDuration time.Duration
+	handler := TimingMiddleware(func(ctx *fasthttp.RequestCtx, duration time.Duration) {
+		observedPath = string(ctx.Path())
+		observedDuration = duration
+	})(HandleRequesterFunc(func(ctx *fasthttp.RequestCtx) error {
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	}))
+
+	assert.NoError(t, handler.HandleRequest(newTestMiddlewareCtx("GET", "/timed")))
+	assert.Equal(t, "/timed", observedPath)
+	assert.True(t, observedDuration >= 10*time.Millisecond, observedDuration)
+}
+
+func TestMaxRequestSizeMiddleware(t *testing.T) {
+	isCalled := false
+	handler := MaxRequestSizeMiddleware(4)(HandleRequesterFunc(func(ctx *fasthttp.RequestCtx) error {
+		isCalled = true
+		return nil
+	}))
+
+	ctx := newTestMiddlewareCtx("POST", "/")
+	ctx.Request.SetBodyString("1234")
+	assert.NoError(t, handler.HandleRequest(ctx))
+	assert.True(t, isCalled)
+	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+
+	isCalled = false
+	ctx = newTestMiddlewareCtx("POST", "/")
+	ctx.Request.SetBodyString("12345")
+	assert.NoError(t, handler.HandleRequest(ctx))
+	assert.False(t, isCalled)
+	assert.Equal(t, fasthttp.StatusRequestEntityTooLarge, ctx.Response.StatusCode())
+}
@@ -0,0 +1,122 @@
+package fasthttpsocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+)
+
+func appendUvarint(dst []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(dst, buf[:n]...)
+}
+
+func appendBytes(dst []byte, b []byte) []byte {
+	dst = appendUvarint(dst, uint64(len(b)))
+	return append(dst, b...)
+}
+
+func readUvarint(src []byte) (uint64, []byte, error) {
+	v, n := binary.Uvarint(src)
+	if n <= 0 {
+		return 0, src, ErrInvalidMessage
+	}
+	return v, src[n:], nil
+}
+
+// readBytes copies the next length-prefixed field of "src" into "dst" (so the
+// result does not reference the decoder's buffer) and returns the rest of "src".
+func readBytes(dst []byte, src []byte) ([]byte, []byte, error) {
+	l, src, err := readUvarint(src)
+	if err != nil {
+		return dst, src, err
+	}
+	if uint64(len(src)) < l {
+		return dst, src, ErrInvalidMessage
+	}
+	return append(dst[:0], src[:l]...), src[l:], nil
+}
+
+func appendString(dst []byte, s string) []byte {
+	dst = appendUvarint(dst, uint64(len(s)))
+	return append(dst, s...)
+}
+
+func readString(src []byte) (string, []byte, error) {
+	l, src, err := readUvarint(src)
+	if err != nil {
+		return "", src, err
+	}
+	if uint64(len(src)) < l {
+		return "", src, ErrInvalidMessage
+	}
+	return string(src[:l]), src[l:], nil
+}
+
+// streamFieldCopier reads a field of a message from a stream and appends its
+// encoding to "dst"
+type streamFieldCopier func(dst []byte, r *bufio.Reader) ([]byte, error)
+
+// readMessage reads a message which consists of "fields" from a stream
+// (so it could be passed to Unmarshal). io.EOF is returned only if the stream
+// ends before the message.
+func readMessage(dst []byte, r *bufio.Reader, fields []streamFieldCopier) ([]byte, error) {
+	for idx, copyField := range fields {
+		var err error
+		if dst, err = copyField(dst, r); err != nil {
+			if idx > 0 && err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return dst, err
+		}
+	}
+	return dst, nil
+}
+
+func copyUvarint(dst []byte, r *bufio.Reader) ([]byte, error) {
+	v, err := binary.ReadUvarint(r)
+	if err != nil {
+		return dst, err
+	}
+	return appendUvarint(dst, v), nil
+}
+
+// copyBytes copies a length-prefixed field. It's read by chunks, so an
+// invalid length doesn't allocate more memory than was received.
+func copyBytes(dst []byte, r *bufio.Reader) ([]byte, error) {
+	l, err := binary.ReadUvarint(r)
+	if err != nil {
+		return dst, err
+	}
+	dst = appendUvarint(dst, l)
+	for l > 0 {
+		chunkSize := l
+		if chunkSize > dummyDecoderBufferSize {
+			chunkSize = dummyDecoderBufferSize
+		}
+		n := len(dst)
+		dst = append(dst, make([]byte, chunkSize)...)
+		if _, err := io.ReadFull(r, dst[n:]); err != nil {
+			return dst, err
+		}
+		l -= chunkSize
+	}
+	return dst, nil
+}
+
+// copyPairs copies a counted list of pairs of length-prefixed fields (like
+// headers or user values)
+func copyPairs(dst []byte, r *bufio.Reader) ([]byte, error) {
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return dst, err
+	}
+	dst = appendUvarint(dst, count)
+	for i := uint64(0); i < 2*count; i++ {
+		if dst, err = copyBytes(dst, r); err != nil {
+			return dst, err
+		}
+	}
+	return dst, nil
+}
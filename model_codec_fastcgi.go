@@ -0,0 +1,377 @@
+package fasthttpsocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/trafficstars/fasthttp"
+)
+
+// See https://fast-cgi.github.io/spec
+
+const (
+	fastCGIVersion = 1
+
+	fastCGIHeaderSize       = 8
+	fastCGIMaxContentLength = 65535
+
+	// we never multiplex requests on a connection, so a constant ID is enough
+	fastCGIRequestID = 1
+
+	fastCGIRoleResponder = 1
+	fastCGIFlagKeepConn  = 1
+
+	fastCGIRequestComplete = 0
+)
+
+const (
+	fastCGITypeBeginRequest = 1 + iota
+	fastCGITypeAbortRequest
+	fastCGITypeEndRequest
+	fastCGITypeParams
+	fastCGITypeStdin
+	fastCGITypeStdout
+	fastCGITypeStderr
+)
+
+var (
+	ErrFastCGIRequestNotComplete = errors.New(`[fasthttp-socket] FastCGI request is not completed`)
+	ErrFastCGIStderr             = errors.New(`[fasthttp-socket] FastCGI application returned an error`)
+	ErrFastCGIInvalidResponse    = errors.New(`[fasthttp-socket] FastCGI application returned an invalid response`)
+)
+
+var _ ClientCodec = newClientCodecFastCGI()
+
+func appendFastCGIRecord(dst []byte, recordType byte, content []byte) []byte {
+	var header [fastCGIHeaderSize]byte
+	header[0] = fastCGIVersion
+	header[1] = recordType
+	binary.BigEndian.PutUint16(header[2:], fastCGIRequestID)
+	binary.BigEndian.PutUint16(header[4:], uint16(len(content)))
+	dst = append(dst, header[:]...)
+	return append(dst, content...)
+}
+
+// appendFastCGIStream splits "content" into records of type "recordType" and
+// terminates the stream with an empty record.
+func appendFastCGIStream(dst []byte, recordType byte, content []byte) []byte {
+	for len(content) > 0 {
+		chunk := content
+		if len(chunk) > fastCGIMaxContentLength {
+			chunk = chunk[:fastCGIMaxContentLength]
+		}
+		dst = appendFastCGIRecord(dst, recordType, chunk)
+		content = content[len(chunk):]
+	}
+	return appendFastCGIRecord(dst, recordType, nil)
+}
+
+func appendFastCGILength(dst []byte, l int) []byte {
+	if l < 0x80 {
+		return append(dst, byte(l))
+	}
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(l)|1<<31)
+	return append(dst, b[:]...)
+}
+
+func appendFastCGIParam(dst []byte, name string, value []byte) []byte {
+	dst = appendFastCGILength(dst, len(name))
+	dst = appendFastCGILength(dst, len(value))
+	dst = append(dst, name...)
+	return append(dst, value...)
+}
+
+func appendFastCGIHeaderParam(dst []byte, key []byte, value []byte) []byte {
+	l := len(dst)
+	dst = append(dst, "HTTP_"...)
+	for _, c := range key {
+		switch {
+		case c == '-':
+			c = '_'
+		case c >= 'a' && c <= 'z':
+			c -= 'a' - 'A'
+		}
+		dst = append(dst, c)
+	}
+	name := string(dst[l:])
+	return appendFastCGIParam(dst[:l], name, value)
+}
+
+func appendFastCGIAddrParams(dst []byte, prefix string, addr net.Addr) []byte {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return dst
+	}
+	dst = appendFastCGIParam(dst, prefix+"_ADDR", []byte(tcpAddr.IP.String()))
+	return appendFastCGIParam(dst, prefix+"_PORT", strconv.AppendInt(nil, int64(tcpAddr.Port), 10))
+}
+
+type modelFastCGIRequest struct {
+	codec  *modelCodecFastCGI
+	params []byte
+	Data   []byte
+}
+
+func (model *modelFastCGIRequest) Release() {
+	model.Reset()
+	model.codec.requestPool.Put(model)
+}
+
+func (model *modelFastCGIRequest) IsRequest() bool {
+	return true
+}
+
+func (model *modelFastCGIRequest) Reset() {
+	model.params = model.params[:0]
+	model.Data = model.Data[:0]
+}
+
+func (model *modelFastCGIRequest) Marshal() []byte {
+	return model.Data
+}
+
+type modelFastCGIResponse struct {
+	codec          *modelCodecFastCGI
+	content        []byte
+	Stdout         []byte
+	Stderr         []byte
+	AppStatus      uint32
+	ProtocolStatus byte
+}
+
+func (model *modelFastCGIResponse) Release() {
+	model.Reset()
+	model.codec.responsePool.Put(model)
+}
+
+func (model *modelFastCGIResponse) IsResponse() bool {
+	return true
+}
+
+func (model *modelFastCGIResponse) Reset() {
+	model.Stdout = model.Stdout[:0]
+	model.Stderr = model.Stderr[:0]
+	model.AppStatus = 0
+	model.ProtocolStatus = 0
+}
+
+// UnmarshalFrom reads records until FCGI_END_REQUEST
+func (model *modelFastCGIResponse) UnmarshalFrom(r *bufio.Reader) error {
+	var header [fastCGIHeaderSize]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return err
+		}
+		if header[0] != fastCGIVersion {
+			return ErrFastCGIInvalidResponse
+		}
+		recordType := header[1]
+		requestID := binary.BigEndian.Uint16(header[2:])
+		contentLength := int(binary.BigEndian.Uint16(header[4:]))
+		paddingLength := int(header[6])
+
+		if cap(model.content) < contentLength+paddingLength {
+			model.content = make([]byte, contentLength+paddingLength)
+		}
+		model.content = model.content[:contentLength+paddingLength]
+		if _, err := io.ReadFull(r, model.content); err != nil {
+			return err
+		}
+		content := model.content[:contentLength]
+
+		if requestID != fastCGIRequestID {
+			continue
+		}
+		switch recordType {
+		case fastCGITypeStdout:
+			model.Stdout = append(model.Stdout, content...)
+		case fastCGITypeStderr:
+			model.Stderr = append(model.Stderr, content...)
+		case fastCGITypeEndRequest:
+			if len(content) < 5 {
+				return ErrFastCGIInvalidResponse
+			}
+			model.AppStatus = binary.BigEndian.Uint32(content)
+			model.ProtocolStatus = content[4]
+			return nil
+		}
+	}
+}
+
+type modelCodecFastCGI struct {
+	requestPool  *sync.Pool
+	responsePool *sync.Pool
+}
+
+func newModelCodecFastCGI() *modelCodecFastCGI {
+	codec := &modelCodecFastCGI{}
+	codec.requestPool = &sync.Pool{
+		New: func() interface{} {
+			return &modelFastCGIRequest{
+				codec: codec,
+			}
+		},
+	}
+	codec.responsePool = &sync.Pool{
+		New: func() interface{} {
+			return &modelFastCGIResponse{
+				codec: codec,
+			}
+		},
+	}
+	return codec
+}
+
+func (codec *modelCodecFastCGI) GetRequest() TransmittableRequest {
+	return codec.requestPool.Get().(TransmittableRequest)
+}
+
+func (codec *modelCodecFastCGI) GetResponse() TransmittableResponse {
+	return codec.responsePool.Get().(TransmittableResponse)
+}
+
+// ClientCodecFastCGI makes SocketClient a FastCGI web server (like nginx or
+// Apache in front of php-fpm).
+type ClientCodecFastCGI struct {
+	*modelCodecFastCGI
+
+	// Params are additional FastCGI params sent with every request (for
+	// example: SCRIPT_FILENAME, DOCUMENT_ROOT). They override the params
+	// generated from the request.
+	Params map[string]string
+
+	// Logger (if set) logs the stderr of the application if it also
+	// returned a response.
+	Logger Logger
+}
+
+func newClientCodecFastCGI() *ClientCodecFastCGI {
+	codec := &ClientCodecFastCGI{}
+	codec.modelCodecFastCGI = newModelCodecFastCGI()
+	return codec
+}
+
+func (codec *ClientCodecFastCGI) Encode(modelI TransmittableRequest, ctx *fasthttp.RequestCtx) error {
+	src := &ctx.Request
+	dst := modelI.(*modelFastCGIRequest)
+
+	dst.Reset()
+
+	params := dst.params
+	addParam := func(name string, value []byte) {
+		if _, ok := codec.Params[name]; ok {
+			return
+		}
+		params = appendFastCGIParam(params, name, value)
+	}
+	uri := src.URI()
+	addParam("GATEWAY_INTERFACE", []byte("CGI/1.1"))
+	addParam("SERVER_SOFTWARE", []byte("fasthttpsocket"))
+	addParam("SERVER_PROTOCOL", []byte("HTTP/1.1"))
+	addParam("REQUEST_METHOD", src.Header.Method())
+	addParam("REQUEST_URI", src.Header.RequestURI())
+	addParam("SCRIPT_NAME", uri.Path())
+	addParam("DOCUMENT_URI", uri.Path())
+	addParam("QUERY_STRING", uri.QueryString())
+	addParam("SERVER_NAME", src.Host())
+	addParam("CONTENT_TYPE", src.Header.ContentType())
+	addParam("CONTENT_LENGTH", strconv.AppendInt(nil, int64(len(src.Body())), 10))
+	if ctx.IsTLS() {
+		addParam("HTTPS", []byte("on"))
+	}
+	if _, ok := codec.Params["REMOTE_ADDR"]; !ok {
+		params = appendFastCGIAddrParams(params, "REMOTE", ctx.RemoteAddr())
+	}
+	if _, ok := codec.Params["SERVER_ADDR"]; !ok {
+		params = appendFastCGIAddrParams(params, "SERVER", ctx.LocalAddr())
+	}
+	src.Header.VisitAll(func(key, value []byte) {
+		switch string(key) {
+		case "Content-Type", "Content-Length": // are already passed as CONTENT_TYPE and CONTENT_LENGTH
+			return
+		}
+		if bytes.EqualFold(key, []byte("Proxy")) { // HTTP_PROXY would be taken as a proxy setting ("httpoxy")
+			return
+		}
+		params = appendFastCGIHeaderParam(params, key, value)
+	})
+	for name, value := range codec.Params {
+		params = appendFastCGIParam(params, name, []byte(value))
+	}
+	dst.params = params
+
+	beginRequest := [8]byte{0, fastCGIRoleResponder, fastCGIFlagKeepConn}
+	dst.Data = appendFastCGIRecord(dst.Data, fastCGITypeBeginRequest, beginRequest[:])
+	dst.Data = appendFastCGIStream(dst.Data, fastCGITypeParams, dst.params)
+	dst.Data = appendFastCGIStream(dst.Data, fastCGITypeStdin, src.Body())
+
+	return nil
+}
+
+func (codec *ClientCodecFastCGI) Decode(ctx *fasthttp.RequestCtx, modelI TransmittableResponse) error {
+	src := modelI.(*modelFastCGIResponse)
+	dst := &ctx.Response
+
+	if src.ProtocolStatus != fastCGIRequestComplete {
+		return errors.Wrapf(ErrFastCGIRequestNotComplete, "protocol status %v", src.ProtocolStatus)
+	}
+	if len(src.Stderr) > 0 {
+		if len(src.Stdout) == 0 {
+			return errors.Wrap(ErrFastCGIStderr, string(src.Stderr))
+		}
+		if codec.Logger != nil {
+			codec.Logger.Errorf("[fasthttp-socket-client] FastCGI application stderr: %s\n", src.Stderr)
+		}
+	}
+
+	dst.Reset()
+
+	headerEnd, bodyStart := bytes.Index(src.Stdout, []byte("\r\n\r\n")), 4
+	if idx := bytes.Index(src.Stdout, []byte("\n\n")); idx >= 0 && (headerEnd < 0 || idx < headerEnd) {
+		headerEnd, bodyStart = idx, 2
+	}
+	if headerEnd < 0 {
+		return ErrFastCGIInvalidResponse
+	}
+
+	statusCode := fasthttp.StatusOK
+	hasStatus := false
+	for _, line := range bytes.Split(src.Stdout[:headerEnd], []byte("\n")) {
+		line = bytes.TrimRight(line, "\r")
+		idx := bytes.IndexByte(line, ':')
+		if idx <= 0 {
+			return ErrFastCGIInvalidResponse
+		}
+		key := bytes.TrimSpace(line[:idx])
+		value := bytes.TrimSpace(line[idx+1:])
+		switch {
+		case bytes.EqualFold(key, []byte("Status")):
+			if len(value) > 3 {
+				value = value[:3]
+			}
+			code, err := strconv.Atoi(string(value))
+			if err != nil {
+				return ErrFastCGIInvalidResponse
+			}
+			statusCode = code
+			hasStatus = true
+		case bytes.EqualFold(key, []byte("Location")) && !hasStatus:
+			statusCode = fasthttp.StatusFound
+			dst.Header.AddBytesKV(key, value)
+		default:
+			dst.Header.AddBytesKV(key, value)
+		}
+	}
+
+	dst.SetStatusCode(statusCode)
+	dst.SetBody(src.Stdout[headerEnd+bodyStart:])
+
+	return nil
+}
@@ -0,0 +1,312 @@
+package fasthttpsocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trafficstars/fasthttp"
+)
+
+type testFastCGIRecord struct {
+	recordType byte
+	content    []byte
+}
+
+func appendTestFastCGIRecord(dst []byte, recordType byte, content []byte, paddingLength int) []byte {
+	var header [fastCGIHeaderSize]byte
+	header[0] = fastCGIVersion
+	header[1] = recordType
+	binary.BigEndian.PutUint16(header[2:], fastCGIRequestID)
+	binary.BigEndian.PutUint16(header[4:], uint16(len(content)))
+	header[6] = byte(paddingLength)
+	dst = append(dst, header[:]...)
+	dst = append(dst, content...)
+	return append(dst, make([]byte, paddingLength)...)
+}
+
+func appendTestFastCGIEndRequest(dst []byte, appStatus uint32, protocolStatus byte) []byte {
+	var content [8]byte
+	binary.BigEndian.PutUint32(content[:], appStatus)
+	content[4] = protocolStatus
+	return appendTestFastCGIRecord(dst, fastCGITypeEndRequest, content[:], 0)
+}
+
+func readTestFastCGIRecord(r io.Reader) (*testFastCGIRecord, error) {
+	var header [fastCGIHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	content := make([]byte, int(binary.BigEndian.Uint16(header[4:]))+int(header[6]))
+	if _, err := io.ReadFull(r, content); err != nil {
+		return nil, err
+	}
+	return &testFastCGIRecord{
+		recordType: header[1],
+		content:    content[:binary.BigEndian.Uint16(header[4:])],
+	}, nil
+}
+
+func readTestFastCGILength(b []byte) (int, []byte) {
+	if b[0] < 0x80 {
+		return int(b[0]), b[1:]
+	}
+	return int(binary.BigEndian.Uint32(b) &^ (1 << 31)), b[4:]
+}
+
+func parseTestFastCGIParams(b []byte) map[string]string {
+	params := map[string]string{}
+	for len(b) > 0 {
+		var nameLength, valueLength int
+		nameLength, b = readTestFastCGILength(b)
+		valueLength, b = readTestFastCGILength(b)
+		params[string(b[:nameLength])] = string(b[nameLength : nameLength+valueLength])
+		b = b[nameLength+valueLength:]
+	}
+	return params
+}
+
+// testFastCGIResponder is a fake FastCGI application: it reads a request and
+// responds with the result of "respond" (split into records with padding).
+type testFastCGIResponder struct {
+	respond func(params map[string]string, stdin []byte) (stdout, stderr []byte)
+}
+
+func (responder *testFastCGIResponder) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		var params, stdin []byte
+		for isStdinDone := false; !isStdinDone; {
+			record, err := readTestFastCGIRecord(r)
+			if err != nil {
+				return
+			}
+			switch record.recordType {
+			case fastCGITypeParams:
+				params = append(params, record.content...)
+			case fastCGITypeStdin:
+				stdin = append(stdin, record.content...)
+				isStdinDone = len(record.content) == 0
+			}
+		}
+
+		stdout, stderr := responder.respond(parseTestFastCGIParams(params), stdin)
+		var response []byte
+		if len(stderr) > 0 {
+			response = appendTestFastCGIRecord(response, fastCGITypeStderr, stderr, 3)
+		}
+		half := len(stdout) / 2
+		response = appendTestFastCGIRecord(response, fastCGITypeStdout, stdout[:half], 5)
+		response = appendTestFastCGIRecord(response, fastCGITypeStdout, stdout[half:], 0)
+		response = appendTestFastCGIRecord(response, fastCGITypeStdout, nil, 0)
+		response = appendTestFastCGIEndRequest(response, 0, fastCGIRequestComplete)
+		if _, err := conn.Write(response); err != nil {
+			return
+		}
+	}
+}
+
+type testRecordingLogger struct {
+	locker sync.Mutex
+	errors []string
+}
+
+func (l *testRecordingLogger) Print(args ...interface{}) {}
+
+func (l *testRecordingLogger) Errorf(format string, args ...interface{}) {
+	l.locker.Lock()
+	defer l.locker.Unlock()
+	l.errors = append(l.errors, fmt.Sprintf(format, args...))
+}
+
+func (l *testRecordingLogger) Errors() []string {
+	l.locker.Lock()
+	defer l.locker.Unlock()
+	return append([]string{}, l.errors...)
+}
+
+func TestFastCGIParamLength(t *testing.T) {
+	longValue := bytes.Repeat([]byte("v"), 200)
+	longKey := bytes.Repeat([]byte("k"), 130)
+
+	params := appendFastCGIHeaderParam(nil, []byte("X-Short-header"), []byte("short"))
+	params = appendFastCGIHeaderParam(params, []byte("X-Long"), longValue)
+	params = appendFastCGIHeaderParam(params, longKey, []byte("value"))
+
+	assert.Equal(t, byte(len("HTTP_X_SHORT_HEADER")), params[0])
+	assert.Equal(t, map[string]string{
+		"HTTP_X_SHORT_HEADER":                    "short",
+		"HTTP_X_LONG":                            string(longValue),
+		"HTTP_" + string(bytes.ToUpper(longKey)): "value",
+	}, parseTestFastCGIParams(params))
+
+	// a 4-byte length has the highest bit set
+	b := appendFastCGILength(nil, 200)
+	assert.Equal(t, []byte{0x80, 0, 0, 200}, b)
+	b = appendFastCGILength(nil, 127)
+	assert.Equal(t, []byte{127}, b)
+}
+
+func TestFastCGIEncodeSkipsProxyHeader(t *testing.T) {
+	codec := newClientCodecFastCGI()
+	model := codec.GetRequest().(*modelFastCGIRequest)
+	defer model.Release()
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/index.php")
+	ctx.Request.Header.Set("Proxy", "http://attacker.example:8080")
+	ctx.Request.Header.Set("X-Test", "value")
+	assert.NoError(t, codec.Encode(model, ctx))
+
+	params := parseTestFastCGIParams(model.params)
+	assert.Equal(t, "value", params["HTTP_X_TEST"])
+	_, ok := params["HTTP_PROXY"]
+	assert.False(t, ok, "HTTP_PROXY is passed")
+}
+
+func TestFastCGIUnmarshal(t *testing.T) {
+	var stream []byte
+	stream = appendTestFastCGIRecord(stream, fastCGITypeStdout, []byte("Status: 2"), 7)
+	stream = appendTestFastCGIRecord(stream, fastCGITypeStderr, []byte("warning"), 1)
+	stream = appendTestFastCGIRecord(stream, fastCGITypeStdout, []byte("01\r\n\r\nbody"), 0)
+	stream = appendTestFastCGIRecord(stream, fastCGITypeStdout, nil, 0)
+	stream = appendTestFastCGIEndRequest(stream, 3, fastCGIRequestComplete)
+
+	// the stream is read in small pieces, so records are split
+	model := newClientCodecFastCGI().GetResponse().(*modelFastCGIResponse)
+	r := bufio.NewReaderSize(&testSlowReader{stream}, 16)
+	assert.NoError(t, model.UnmarshalFrom(r))
+	assert.Equal(t, "Status: 201\r\n\r\nbody", string(model.Stdout))
+	assert.Equal(t, "warning", string(model.Stderr))
+	assert.Equal(t, uint32(3), model.AppStatus)
+	assert.Equal(t, byte(fastCGIRequestComplete), model.ProtocolStatus)
+
+	model.Reset()
+	assert.Equal(t, io.ErrUnexpectedEOF, model.UnmarshalFrom(bufio.NewReader(bytes.NewReader(stream[:len(stream)-3]))))
+
+	model.Reset()
+	stream = appendTestFastCGIRecord(nil, fastCGITypeEndRequest, []byte{0, 0}, 0)
+	assert.Equal(t, ErrFastCGIInvalidResponse, model.UnmarshalFrom(bufio.NewReader(bytes.NewReader(stream))))
+}
+
+// testSlowReader returns at most 3 bytes per Read
+type testSlowReader struct {
+	b []byte
+}
+
+func (r *testSlowReader) Read(b []byte) (int, error) {
+	if len(r.b) == 0 {
+		return 0, io.EOF
+	}
+	if len(b) > 3 {
+		b = b[:3]
+	}
+	n := copy(b, r.b)
+	r.b = r.b[n:]
+	return n, nil
+}
+
+func TestFastCGIDecode(t *testing.T) {
+	codec := newClientCodecFastCGI()
+	decode := func(stdout string, protocolStatus byte) (*fasthttp.Response, error) {
+		model := codec.GetResponse().(*modelFastCGIResponse)
+		defer model.Release()
+		model.Stdout = append(model.Stdout, stdout...)
+		model.ProtocolStatus = protocolStatus
+		ctx := &fasthttp.RequestCtx{}
+		err := codec.Decode(ctx, model)
+		return &ctx.Response, err
+	}
+
+	resp, err := decode("Content-Type: text/plain\r\nX-Test: a\r\n\r\nbody\n\nwith blank lines", fastCGIRequestComplete)
+	assert.NoError(t, err)
+	assert.Equal(t, fasthttp.StatusOK, resp.StatusCode())
+	assert.Equal(t, "text/plain", string(resp.Header.ContentType()))
+	assert.Equal(t, "a", string(resp.Header.Peek("X-Test")))
+	assert.Equal(t, "body\n\nwith blank lines", string(resp.Body()))
+
+	resp, err = decode("X-Test: b\n\nbody\r\n\r\n", fastCGIRequestComplete)
+	assert.NoError(t, err)
+	assert.Equal(t, "b", string(resp.Header.Peek("X-Test")))
+	assert.Equal(t, "body\r\n\r\n", string(resp.Body()))
+
+	resp, err = decode("Status: 404 Not Found\r\n\r\n", fastCGIRequestComplete)
+	assert.NoError(t, err)
+	assert.Equal(t, fasthttp.StatusNotFound, resp.StatusCode())
+
+	resp, err = decode("Location: /other\r\n\r\n", fastCGIRequestComplete)
+	assert.NoError(t, err)
+	assert.Equal(t, fasthttp.StatusFound, resp.StatusCode())
+	assert.Equal(t, "/other", string(resp.Header.Peek("Location")))
+
+	resp, err = decode("Status: 301\r\nLocation: /moved\r\n\r\n", fastCGIRequestComplete)
+	assert.NoError(t, err)
+	assert.Equal(t, fasthttp.StatusMovedPermanently, resp.StatusCode())
+
+	_, err = decode("no headers", fastCGIRequestComplete)
+	assert.Equal(t, ErrFastCGIInvalidResponse, err)
+
+	_, err = decode("Status: 200\r\n\r\n", 2)
+	assert.Error(t, err)
+}
+
+func TestFastCGIClient(t *testing.T) {
+	responder := &testFastCGIResponder{
+		respond: func(params map[string]string, stdin []byte) ([]byte, []byte) {
+			var stderr []byte
+			if params["REQUEST_METHOD"] == "POST" {
+				stderr = []byte("got a POST")
+			}
+			return []byte(fmt.Sprintf("Status: 201 Created\r\nX-Uri: %s\r\nX-Script: %s\r\nX-Header: %s\r\n\r\n%s",
+				params["REQUEST_URI"], params["SCRIPT_FILENAME"], params["HTTP_X_LONG_HEADER"], stdin)), stderr
+		},
+	}
+
+	logger := &testRecordingLogger{}
+	client, err := NewSocketClient(Config{
+		Address:       "fastcgi:native:unix:/fake/php-fpm.sock",
+		Logger:        logger,
+		FastCGIParams: map[string]string{"SCRIPT_FILENAME": "/var/www/index.php"},
+		Dial: func(network, address string) (net.Conn, error) {
+			clientConn, serverConn := net.Pipe()
+			go responder.serve(serverConn)
+			return clientConn, nil
+		},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.NoError(t, client.Start(1)) {
+		return
+	}
+	defer client.Close()
+
+	longHeader := string(bytes.Repeat([]byte("h"), 300))
+	for _, method := range []string{"GET", "POST"} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(method)
+		ctx.Request.SetRequestURI("/index.php?a=b")
+		ctx.Request.Header.Set("X-Long-Header", longHeader)
+		ctx.Request.SetBodyString("request body")
+		if !assert.NoError(t, client.SendAndReceive(ctx)) {
+			return
+		}
+		assert.Equal(t, fasthttp.StatusCreated, ctx.Response.StatusCode())
+		assert.Equal(t, "/index.php?a=b", string(ctx.Response.Header.Peek("X-Uri")))
+		assert.Equal(t, "/var/www/index.php", string(ctx.Response.Header.Peek("X-Script")))
+		assert.Equal(t, longHeader, string(ctx.Response.Header.Peek("X-Header")))
+		assert.Equal(t, "request body", string(ctx.Response.Body()))
+	}
+
+	errors := logger.Errors()
+	if assert.Len(t, errors, 1) {
+		assert.Contains(t, errors[0], "got a POST")
+	}
+}
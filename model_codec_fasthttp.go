@@ -0,0 +1,394 @@
+package fasthttpsocket
+
+import (
+	"bufio"
+	"sync"
+
+	"github.com/trafficstars/fasthttp"
+)
+
+var _ ClientCodec = newClientCodecFastHttp()
+var _ ServerCodec = newServerCodecFastHttp()
+
+// the layouts of messages written by Marshal (to find their ends in streams)
+var (
+	modelFastHttpRequestFields = []streamFieldCopier{
+		copyUvarint,  // RequestID
+		copyBytes,    // Method
+		copyBytes,    // RequestURI
+		copyPairs,    // Headers
+		copyPairs,    // Cookies
+		copyBytes,    // Body
+		copyConnInfo, // ConnInfo
+		copyPairs,    // UserValues
+	}
+	modelFastHttpResponseFields = []streamFieldCopier{
+		copyUvarint, // RequestID
+		copyUvarint, // StatusCode
+		copyPairs,   // Headers
+		copyPairs,   // Cookies
+		copyBytes,   // Body
+		copyPairs,   // UserValues
+	}
+)
+
+type modelFastHttpHeader struct {
+	Key   []byte
+	Value []byte
+}
+
+type modelFastHttpHeaders []modelFastHttpHeader
+
+func (headers modelFastHttpHeaders) Add(key, value []byte) modelFastHttpHeaders {
+	n := len(headers)
+	if n < cap(headers) {
+		headers = headers[:n+1]
+	} else {
+		headers = append(headers, modelFastHttpHeader{})
+	}
+	kv := &headers[n]
+	kv.Key = append(kv.Key[:0], key...)
+	kv.Value = append(kv.Value[:0], value...)
+	return headers
+}
+
+func (headers modelFastHttpHeaders) marshalTo(dst []byte) []byte {
+	dst = appendUvarint(dst, uint64(len(headers)))
+	for _, kv := range headers {
+		dst = appendBytes(dst, kv.Key)
+		dst = appendBytes(dst, kv.Value)
+	}
+	return dst
+}
+
+func (headers modelFastHttpHeaders) unmarshalFrom(src []byte) (modelFastHttpHeaders, []byte, error) {
+	count, src, err := readUvarint(src)
+	if err != nil {
+		return headers, src, err
+	}
+	if count > uint64(len(src)) { // each header takes at least two bytes
+		return headers, src, ErrInvalidMessage
+	}
+	headers = headers[:0]
+	for i := uint64(0); i < count; i++ {
+		headers = headers.Add(nil, nil)
+		kv := &headers[len(headers)-1]
+		if kv.Key, src, err = readBytes(kv.Key, src); err != nil {
+			return headers, src, err
+		}
+		if kv.Value, src, err = readBytes(kv.Value, src); err != nil {
+			return headers, src, err
+		}
+	}
+	return headers, src, nil
+}
+
+type modelFastHttpRequest struct {
+	codec *modelCodecFastHttp
+	buf   []byte
+
+	RequestID  uint64
+	Method     []byte
+	RequestURI []byte
+	Headers    modelFastHttpHeaders
+	Cookies    modelFastHttpHeaders
+	Body       []byte
+	ConnInfo   ConnInfo
+	UserValues UserValues
+}
+
+func (model *modelFastHttpRequest) Release() {
+	model.Reset()
+	model.codec.requestPool.Put(model)
+}
+
+func (model *modelFastHttpRequest) IsRequest() bool {
+	return true
+}
+
+func (model *modelFastHttpRequest) Reset() {
+	model.RequestID = 0
+	model.Method = model.Method[:0]
+	model.RequestURI = model.RequestURI[:0]
+	model.Headers = model.Headers[:0]
+	model.Cookies = model.Cookies[:0]
+	model.Body = model.Body[:0]
+	model.ConnInfo.Reset()
+	model.UserValues.Reset()
+}
+
+func (model *modelFastHttpRequest) requestID() *uint64 {
+	return &model.RequestID
+}
+
+func (model *modelFastHttpRequest) connInfo() *ConnInfo {
+	return &model.ConnInfo
+}
+
+func (model *modelFastHttpRequest) userValues() *UserValues {
+	return &model.UserValues
+}
+
+func (model *modelFastHttpRequest) Marshal() []byte {
+	b := model.buf[:0]
+	b = appendUvarint(b, model.RequestID)
+	b = appendBytes(b, model.Method)
+	b = appendBytes(b, model.RequestURI)
+	b = model.Headers.marshalTo(b)
+	b = model.Cookies.marshalTo(b)
+	b = appendBytes(b, model.Body)
+	b = model.ConnInfo.marshalTo(b)
+	b = model.UserValues.marshalTo(b)
+	model.buf = b
+	return b
+}
+
+func (model *modelFastHttpRequest) Unmarshal(b []byte) (err error) {
+	if model.RequestID, b, err = readUvarint(b); err != nil {
+		return
+	}
+	if model.Method, b, err = readBytes(model.Method, b); err != nil {
+		return
+	}
+	if model.RequestURI, b, err = readBytes(model.RequestURI, b); err != nil {
+		return
+	}
+	if model.Headers, b, err = model.Headers.unmarshalFrom(b); err != nil {
+		return
+	}
+	if model.Cookies, b, err = model.Cookies.unmarshalFrom(b); err != nil {
+		return
+	}
+	if model.Body, b, err = readBytes(model.Body, b); err != nil {
+		return
+	}
+	if b, err = model.ConnInfo.unmarshalFrom(b); err != nil {
+		return
+	}
+	_, err = model.UserValues.unmarshalFrom(b)
+	return
+}
+
+func (model *modelFastHttpRequest) UnmarshalFrom(r *bufio.Reader) (err error) {
+	if model.buf, err = readMessage(model.buf[:0], r, modelFastHttpRequestFields); err != nil {
+		return
+	}
+	return model.Unmarshal(model.buf)
+}
+
+type modelFastHttpResponse struct {
+	codec *modelCodecFastHttp
+	buf   []byte
+
+	RequestID  uint64
+	StatusCode int
+	Headers    modelFastHttpHeaders
+	Cookies    modelFastHttpHeaders
+	Body       []byte
+	UserValues UserValues
+}
+
+func (model *modelFastHttpResponse) Release() {
+	model.Reset()
+	model.codec.responsePool.Put(model)
+}
+
+func (model *modelFastHttpResponse) IsResponse() bool {
+	return true
+}
+
+func (model *modelFastHttpResponse) Reset() {
+	model.RequestID = 0
+	model.StatusCode = 0
+	model.Headers = model.Headers[:0]
+	model.Cookies = model.Cookies[:0]
+	model.Body = model.Body[:0]
+	model.UserValues.Reset()
+}
+
+func (model *modelFastHttpResponse) requestID() *uint64 {
+	return &model.RequestID
+}
+
+func (model *modelFastHttpResponse) userValues() *UserValues {
+	return &model.UserValues
+}
+
+func (model *modelFastHttpResponse) Marshal() []byte {
+	b := model.buf[:0]
+	b = appendUvarint(b, model.RequestID)
+	b = appendUvarint(b, uint64(model.StatusCode))
+	b = model.Headers.marshalTo(b)
+	b = model.Cookies.marshalTo(b)
+	b = appendBytes(b, model.Body)
+	b = model.UserValues.marshalTo(b)
+	model.buf = b
+	return b
+}
+
+func (model *modelFastHttpResponse) Unmarshal(b []byte) (err error) {
+	if model.RequestID, b, err = readUvarint(b); err != nil {
+		return
+	}
+	var statusCode uint64
+	if statusCode, b, err = readUvarint(b); err != nil {
+		return
+	}
+	model.StatusCode = int(statusCode)
+	if model.Headers, b, err = model.Headers.unmarshalFrom(b); err != nil {
+		return
+	}
+	if model.Cookies, b, err = model.Cookies.unmarshalFrom(b); err != nil {
+		return
+	}
+	if model.Body, b, err = readBytes(model.Body, b); err != nil {
+		return
+	}
+	_, err = model.UserValues.unmarshalFrom(b)
+	return
+}
+
+func (model *modelFastHttpResponse) UnmarshalFrom(r *bufio.Reader) (err error) {
+	if model.buf, err = readMessage(model.buf[:0], r, modelFastHttpResponseFields); err != nil {
+		return
+	}
+	return model.Unmarshal(model.buf)
+}
+
+type modelCodecFastHttp struct {
+	requestPool  *sync.Pool
+	responsePool *sync.Pool
+}
+
+func newModelCodecFastHttp() *modelCodecFastHttp {
+	codec := &modelCodecFastHttp{}
+	codec.requestPool = &sync.Pool{
+		New: func() interface{} {
+			return &modelFastHttpRequest{
+				codec: codec,
+			}
+		},
+	}
+	codec.responsePool = &sync.Pool{
+		New: func() interface{} {
+			return &modelFastHttpResponse{
+				codec: codec,
+			}
+		},
+	}
+	return codec
+}
+
+func (codec *modelCodecFastHttp) GetRequest() TransmittableRequest {
+	return codec.requestPool.Get().(TransmittableRequest)
+}
+
+func (codec *modelCodecFastHttp) GetResponse() TransmittableResponse {
+	return codec.responsePool.Get().(TransmittableResponse)
+}
+
+type ClientCodecFastHttp struct {
+	*modelCodecFastHttp
+}
+
+func newClientCodecFastHttp() *ClientCodecFastHttp {
+	codec := &ClientCodecFastHttp{}
+	codec.modelCodecFastHttp = newModelCodecFastHttp()
+	return codec
+}
+
+func (codec *ClientCodecFastHttp) Encode(modelI TransmittableRequest, ctx *fasthttp.RequestCtx) error {
+	src := &ctx.Request
+	dst := modelI.(*modelFastHttpRequest)
+
+	dst.Reset()
+	dst.Method = append(dst.Method, src.Header.Method()...)
+	dst.RequestURI = append(dst.RequestURI, src.Header.RequestURI()...)
+	src.Header.VisitAll(func(key, value []byte) {
+		switch string(key) {
+		case "Cookie", "Content-Length": // are transferred as Cookies and Body
+			return
+		}
+		dst.Headers = dst.Headers.Add(key, value)
+	})
+	src.Header.VisitAllCookie(func(key, value []byte) {
+		dst.Cookies = dst.Cookies.Add(key, value)
+	})
+	dst.Body = append(dst.Body, src.Body()...)
+
+	return nil
+}
+
+func (codec *ClientCodecFastHttp) Decode(ctx *fasthttp.RequestCtx, modelI TransmittableResponse) error {
+	src := modelI.(*modelFastHttpResponse)
+	dst := &ctx.Response
+
+	dst.Reset()
+	dst.SetStatusCode(src.StatusCode)
+	for _, kv := range src.Headers {
+		dst.Header.AddBytesKV(kv.Key, kv.Value)
+	}
+	if len(src.Cookies) > 0 {
+		cookie := fasthttp.AcquireCookie()
+		for _, kv := range src.Cookies {
+			if err := cookie.ParseBytes(kv.Value); err != nil {
+				fasthttp.ReleaseCookie(cookie)
+				return err
+			}
+			dst.Header.SetCookie(cookie)
+		}
+		fasthttp.ReleaseCookie(cookie)
+	}
+	dst.SetBody(src.Body)
+
+	return nil
+}
+
+type ServerCodecFastHttp struct {
+	*modelCodecFastHttp
+}
+
+func newServerCodecFastHttp() *ServerCodecFastHttp {
+	codec := &ServerCodecFastHttp{}
+	codec.modelCodecFastHttp = newModelCodecFastHttp()
+	return codec
+}
+
+func (codec *ServerCodecFastHttp) Decode(ctx *fasthttp.RequestCtx, modelI TransmittableRequest) error {
+	src := modelI.(*modelFastHttpRequest)
+	dst := &ctx.Request
+
+	dst.Reset()
+	dst.Header.SetMethodBytes(src.Method)
+	dst.Header.SetRequestURIBytes(src.RequestURI)
+	for _, kv := range src.Headers {
+		dst.Header.AddBytesKV(kv.Key, kv.Value)
+	}
+	for _, kv := range src.Cookies {
+		dst.Header.SetCookieBytesKV(kv.Key, kv.Value)
+	}
+	dst.SetBody(src.Body)
+
+	return nil
+}
+
+func (codec *ServerCodecFastHttp) Encode(modelI TransmittableResponse, ctx *fasthttp.RequestCtx) error {
+	src := &ctx.Response
+	dst := modelI.(*modelFastHttpResponse)
+
+	dst.Reset()
+	dst.StatusCode = src.StatusCode()
+	src.Header.VisitAll(func(key, value []byte) {
+		switch string(key) {
+		case "Set-Cookie", "Content-Length": // are transferred as Cookies and Body
+			return
+		}
+		dst.Headers = dst.Headers.Add(key, value)
+	})
+	src.Header.VisitAllCookie(func(key, value []byte) {
+		dst.Cookies = dst.Cookies.Add(key, value)
+	})
+	dst.Body = append(dst.Body, src.Body()...)
+
+	return nil
+}
@@ -0,0 +1,157 @@
+package fasthttpsocket
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trafficstars/fasthttp"
+)
+
+var testSerializers = []string{"native", "gob", "json"}
+
+func testTransmit(t *testing.T, dataModel, serializer string, src, dst interface{}) {
+	newEncoderFunc, newDecoderFunc, _, _, _, err := parseConfig(&Config{
+		Address: dataModel + ":" + serializer + ":unixpacket:/dev/null",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, newEncoderFunc(&buf).Encode(src))
+	assert.NoError(t, newDecoderFunc(&buf).Decode(dst))
+}
+
+func TestDataModelFastHttp(t *testing.T) {
+	for _, serializer := range testSerializers {
+		clientCodec := newClientCodecFastHttp()
+		serverCodec := newServerCodecFastHttp()
+
+		clientCtx := &fasthttp.RequestCtx{}
+		clientCtx.Request.Header.SetMethod(`POST`)
+		clientCtx.Request.SetRequestURI(`/path?a=b`)
+		clientCtx.Request.Header.Set(`Host`, `trafficstars.com`)
+		clientCtx.Request.Header.Set(`X-Test`, `value`)
+		clientCtx.Request.Header.SetCookie(`session`, `abc`)
+		clientCtx.Request.SetBodyString(`request body`)
+
+		clientCtx.SetUserValue(`principal`, `user1`)
+		clientCtx.SetUserValue(`private`, `secret`)
+
+		request := clientCodec.GetRequest()
+		assert.NoError(t, clientCodec.Encode(request, clientCtx))
+		request.(userValuesCarrier).userValues().fill(clientCtx, []string{`principal`})
+		receivedRequest := serverCodec.GetRequest()
+		testTransmit(t, "fasthttp", serializer, request, receivedRequest)
+
+		serverCtx := &fasthttp.RequestCtx{}
+		assert.NoError(t, serverCodec.Decode(serverCtx, receivedRequest), serializer)
+		assert.Equal(t, `POST`, string(serverCtx.Request.Header.Method()), serializer)
+		assert.Equal(t, `/path?a=b`, string(serverCtx.Request.Header.RequestURI()), serializer)
+		assert.Equal(t, `trafficstars.com`, string(serverCtx.Request.Host()), serializer)
+		assert.Equal(t, `value`, string(serverCtx.Request.Header.Peek(`X-Test`)), serializer)
+		assert.Equal(t, `abc`, string(serverCtx.Request.Header.Cookie(`session`)), serializer)
+		assert.Equal(t, `request body`, string(serverCtx.Request.Body()), serializer)
+		receivedRequest.(userValuesCarrier).userValues().apply(serverCtx, []string{`principal`, `private`}, true)
+		assert.Equal(t, `user1`, serverCtx.UserValue(`principal`), serializer)
+		assert.Nil(t, serverCtx.UserValue(`private`), serializer)
+
+		serverCtx.SetStatusCode(fasthttp.StatusNotFound)
+		serverCtx.Response.Header.Set(`X-Test`, `response value`)
+		cookie := fasthttp.AcquireCookie()
+		cookie.SetKey(`session`)
+		cookie.SetValue(`def`)
+		serverCtx.Response.Header.SetCookie(cookie)
+		fasthttp.ReleaseCookie(cookie)
+		serverCtx.SetBodyString(`response body`)
+
+		response := serverCodec.GetResponse()
+		assert.NoError(t, serverCodec.Encode(response, serverCtx))
+		receivedResponse := clientCodec.GetResponse()
+		testTransmit(t, "fasthttp", serializer, response, receivedResponse)
+
+		assert.NoError(t, clientCodec.Decode(clientCtx, receivedResponse), serializer)
+		assert.Equal(t, fasthttp.StatusNotFound, clientCtx.Response.StatusCode(), serializer)
+		assert.Equal(t, `response value`, string(clientCtx.Response.Header.Peek(`X-Test`)), serializer)
+		assert.Equal(t, `response body`, string(clientCtx.Response.Body()), serializer)
+		cookie = fasthttp.AcquireCookie()
+		cookie.SetKey(`session`)
+		assert.True(t, clientCtx.Response.Header.Cookie(cookie), serializer)
+		assert.Equal(t, `def`, string(cookie.Value()), serializer)
+		fasthttp.ReleaseCookie(cookie)
+
+		request.Release()
+		receivedRequest.Release()
+		response.Release()
+		receivedResponse.Release()
+	}
+}
+
+func TestDataModelFastHttpStream(t *testing.T) {
+	clientCodec := newClientCodecFastHttp()
+	serverCodec := newServerCodecFastHttp()
+
+	clientCtx := &fasthttp.RequestCtx{}
+	clientCtx.Request.Header.SetMethod(`POST`)
+	clientCtx.Request.SetRequestURI(`/path`)
+	clientCtx.Request.Header.Set(`X-Test`, `value`)
+	clientCtx.Request.SetBody(bytes.Repeat([]byte(`x`), 3*dummyDecoderBufferSize))
+
+	request := clientCodec.GetRequest()
+	defer request.Release()
+	assert.NoError(t, clientCodec.Encode(request, clientCtx))
+	b := request.(Marshaler).Marshal()
+
+	// two messages in a stream, which is read byte by byte
+	stream := append(append([]byte(nil), b...), b...)
+	r := bufio.NewReader(iotest.OneByteReader(bytes.NewReader(stream)))
+	receivedRequest := serverCodec.GetRequest()
+	defer receivedRequest.Release()
+	for i := 0; i < 2; i++ {
+		if !assert.NoError(t, receivedRequest.(StreamUnmarshaler).UnmarshalFrom(r)) {
+			return
+		}
+		serverCtx := &fasthttp.RequestCtx{}
+		assert.NoError(t, serverCodec.Decode(serverCtx, receivedRequest))
+		assert.Equal(t, `/path`, string(serverCtx.Request.Header.RequestURI()))
+		assert.Equal(t, `value`, string(serverCtx.Request.Header.Peek(`X-Test`)))
+		assert.Equal(t, clientCtx.Request.Body(), serverCtx.Request.Body())
+	}
+	assert.Equal(t, io.EOF, receivedRequest.(StreamUnmarshaler).UnmarshalFrom(r))
+
+	// a truncated message
+	r = bufio.NewReader(bytes.NewReader(b[:len(b)-1]))
+	assert.Equal(t, io.ErrUnexpectedEOF, receivedRequest.(StreamUnmarshaler).UnmarshalFrom(r))
+}
+
+func TestDataModelFastHttpUnixStream(t *testing.T) {
+	address := "fasthttp:native:unix:" + filepath.Join(t.TempDir(), "stream.sock")
+	srv := startTestServer(t, address, func(ctx *fasthttp.RequestCtx) {
+		ctx.SetBody(append(ctx.Request.Body(), ctx.Request.Body()...))
+	})
+	defer srv.Stop()
+
+	client, err := NewSocketClient(Config{Address: address})
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.NoError(t, client.Start(1)) {
+		return
+	}
+	defer client.Close()
+
+	body := bytes.Repeat([]byte(`0123456789`), dummyDecoderBufferSize/5)
+	for i := 0; i < 3; i++ {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(`POST`)
+		ctx.Request.SetBody(body)
+		if assert.NoError(t, client.SendAndReceive(ctx)) {
+			assert.Equal(t, append(append([]byte(nil), body...), body...), ctx.Response.Body())
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package fasthttpsocket
+
+import (
+	"bufio"
+	"bytes"
+	"sync"
+
+	"github.com/trafficstars/fasthttp"
+)
+
+var _ ClientCodec = newClientCodecHttp1()
+var _ ServerCodec = newServerCodecHttp1()
+
+// modelHttp1Request is a request in plain HTTP/1.1 format. "Data" is used to
+// send the request and "Request" is used to receive it.
+type modelHttp1Request struct {
+	codec *modelCodecHttp1
+	Data  []byte
+	fasthttp.Request
+}
+
+func (model *modelHttp1Request) Release() {
+	model.Reset()
+	model.codec.requestPool.Put(model)
+}
+
+func (model *modelHttp1Request) IsRequest() bool {
+	return true
+}
+
+func (model *modelHttp1Request) Reset() {
+	model.Data = nil
+	model.Request.Reset()
+}
+
+func (model *modelHttp1Request) Marshal() []byte {
+	return model.Data
+}
+
+func (model *modelHttp1Request) UnmarshalFrom(r *bufio.Reader) error {
+	return model.Request.Read(r)
+}
+
+// modelHttp1Response is a response in plain HTTP/1.1 format. "Data" is used to
+// send the response and "Response" is used to receive it.
+type modelHttp1Response struct {
+	codec *modelCodecHttp1
+	Data  []byte
+	fasthttp.Response
+}
+
+func (model *modelHttp1Response) Release() {
+	model.Reset()
+	model.codec.responsePool.Put(model)
+}
+
+func (model *modelHttp1Response) IsResponse() bool {
+	return true
+}
+
+func (model *modelHttp1Response) Reset() {
+	model.Data = nil
+	model.Response.Reset()
+}
+
+func (model *modelHttp1Response) Marshal() []byte {
+	return model.Data
+}
+
+func (model *modelHttp1Response) UnmarshalFrom(r *bufio.Reader) error {
+	// a response to HEAD has Content-Length, but doesn't have a body
+	model.Response.SkipBody = model.codec.popHeadRequest()
+	return model.Response.Read(r)
+}
+
+type modelCodecHttp1 struct {
+	requestPool  *sync.Pool
+	responsePool *sync.Pool
+	buf          bytes.Buffer
+	headRequests []bool // of sent requests in order (pipelined responses come in the same order)
+}
+
+func newModelCodecHttp1() *modelCodecHttp1 {
+	codec := &modelCodecHttp1{}
+	codec.requestPool = &sync.Pool{
+		New: func() interface{} {
+			return &modelHttp1Request{
+				codec: codec,
+			}
+		},
+	}
+	codec.responsePool = &sync.Pool{
+		New: func() interface{} {
+			return &modelHttp1Response{
+				codec: codec,
+			}
+		},
+	}
+	return codec
+}
+
+// popHeadRequest returns true if the oldest request without a response is HEAD
+func (codec *modelCodecHttp1) popHeadRequest() bool {
+	if len(codec.headRequests) == 0 {
+		return false
+	}
+	isHead := codec.headRequests[0]
+	codec.headRequests = codec.headRequests[:copy(codec.headRequests, codec.headRequests[1:])]
+	return isHead
+}
+
+// onReconnect forgets requests sent via the previous connection
+func (codec *modelCodecHttp1) onReconnect() {
+	codec.headRequests = codec.headRequests[:0]
+}
+
+func (codec *modelCodecHttp1) GetRequest() TransmittableRequest {
+	return codec.requestPool.Get().(TransmittableRequest)
+}
+
+func (codec *modelCodecHttp1) GetResponse() TransmittableResponse {
+	return codec.responsePool.Get().(TransmittableResponse)
+}
+
+type ClientCodecHttp1 struct {
+	*modelCodecHttp1
+
+	hostRequest fasthttp.Request // a copy of a request without "Host"
+}
+
+func newClientCodecHttp1() *ClientCodecHttp1 {
+	codec := &ClientCodecHttp1{}
+	codec.modelCodecHttp1 = newModelCodecHttp1()
+	return codec
+}
+
+func (codec *ClientCodecHttp1) Encode(modelI TransmittableRequest, ctx *fasthttp.RequestCtx) error {
+	src := &ctx.Request
+	dst := modelI.(*modelHttp1Request)
+
+	if len(src.Host()) == 0 { // HTTP/1.1 requires "Host"
+		src.CopyTo(&codec.hostRequest)
+		codec.hostRequest.URI().SetHost("localhost")
+		src = &codec.hostRequest
+	}
+
+	codec.buf.Reset()
+	_, err := src.WriteTo(&codec.buf)
+	if err != nil {
+		return err
+	}
+
+	codec.headRequests = append(codec.headRequests, src.Header.IsHead())
+	dst.Data = codec.buf.Bytes()
+	return nil
+}
+
+func (codec *ClientCodecHttp1) Decode(ctx *fasthttp.RequestCtx, modelI TransmittableResponse) error {
+	src := modelI.(*modelHttp1Response)
+
+	src.Response.CopyTo(&ctx.Response)
+	return nil
+}
+
+type ServerCodecHttp1 struct {
+	*modelCodecHttp1
+}
+
+func newServerCodecHttp1() *ServerCodecHttp1 {
+	codec := &ServerCodecHttp1{}
+	codec.modelCodecHttp1 = newModelCodecHttp1()
+	return codec
+}
+
+func (codec *ServerCodecHttp1) Decode(ctx *fasthttp.RequestCtx, modelI TransmittableRequest) error {
+	src := modelI.(*modelHttp1Request)
+
+	src.Request.CopyTo(&ctx.Request)
+	return nil
+}
+
+func (codec *ServerCodecHttp1) Encode(modelI TransmittableResponse, ctx *fasthttp.RequestCtx) error {
+	src := &ctx.Response
+	dst := modelI.(*modelHttp1Response)
+
+	codec.buf.Reset()
+	_, err := src.WriteTo(&codec.buf)
+	if err != nil {
+		return err
+	}
+
+	dst.Data = codec.buf.Bytes()
+	return nil
+}
@@ -0,0 +1,103 @@
+package fasthttpsocket
+
+import (
+	"net"
+	"net/http"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trafficstars/fasthttp"
+)
+
+func TestDataModelHttp1NetHttpServer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "http1.sock")
+	listener, err := net.Listen("unix", path)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var connCount int32
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Host", r.Host)
+			switch r.URL.Path {
+			case "/chunked":
+				_, _ = w.Write([]byte("first,"))
+				w.(http.Flusher).Flush()
+				_, _ = w.Write([]byte("second"))
+			case "/head":
+				w.Header().Set("Content-Length", "10")
+			case "/close":
+				w.Header().Set("Connection", "close")
+				_, _ = w.Write([]byte("closing"))
+			default:
+				_, _ = w.Write([]byte("path " + r.URL.Path))
+			}
+		}),
+		ConnState: func(conn net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				atomic.AddInt32(&connCount, 1)
+			}
+		},
+	}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Close()
+
+	client, err := NewSocketClient(Config{
+		Address: "http1:native:unix:" + path,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.NoError(t, client.Start(1)) {
+		return
+	}
+	defer client.Close()
+
+	send := func(method, uri string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(method)
+		ctx.Request.SetRequestURI(uri)
+		assert.NoError(t, client.SendAndReceive(ctx))
+		return ctx
+	}
+
+	// a request without "Host" (the request of the caller is not changed)
+	ctx := send("GET", "/a")
+	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	assert.Equal(t, "localhost", string(ctx.Response.Header.Peek("X-Host")))
+	assert.Equal(t, "path /a", string(ctx.Response.Body()))
+	assert.Empty(t, ctx.Request.Host())
+
+	// keep-alive
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/b")
+	ctx.Request.Header.SetHost("example.com")
+	assert.NoError(t, client.SendAndReceive(ctx))
+	assert.Equal(t, "example.com", string(ctx.Response.Header.Peek("X-Host")))
+	assert.Equal(t, "path /b", string(ctx.Response.Body()))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&connCount))
+
+	ctx = send("GET", "/chunked")
+	assert.Equal(t, "first,second", string(ctx.Response.Body()))
+
+	ctx = send("HEAD", "/head")
+	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	assert.Empty(t, ctx.Response.Body())
+
+	// the body of the HEAD response is not expected, so the connection is in sync
+	ctx = send("GET", "/c")
+	assert.Equal(t, "path /c", string(ctx.Response.Body()))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&connCount))
+
+	// the client reconnects after "Connection: close"
+	ctx = send("GET", "/close")
+	assert.Equal(t, "closing", string(ctx.Response.Body()))
+	ctx = send("GET", "/d")
+	assert.Equal(t, "path /d", string(ctx.Response.Body()))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&connCount))
+}
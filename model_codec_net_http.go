@@ -1,31 +1,92 @@
 package fasthttpsocket
 
 import (
-	"bufio"
-	"bytes"
-	"encoding/gob"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
 	"sync"
 
 	"github.com/trafficstars/fasthttp"
 )
 
-func init() {
-	gob.Register(ioutil.NopCloser(nil))
-	var i io.Reader
-	i = &BytesReader{}
-	gob.Register(i)
-	gob.Register(http.NoBody)
-}
+const (
+	defaultNetHttpProto = "HTTP/1.1"
+)
 
 var _ ClientCodec = newClientCodecNetHttp()
 var _ ServerCodec = newServerCodecNetHttp()
 
+func marshalNetHttpHeader(dst []byte, header http.Header) []byte {
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	dst = appendUvarint(dst, uint64(len(keys)))
+	for _, key := range keys {
+		values := header[key]
+		dst = appendString(dst, key)
+		dst = appendUvarint(dst, uint64(len(values)))
+		for _, value := range values {
+			dst = appendString(dst, value)
+		}
+	}
+	return dst
+}
+
+func unmarshalNetHttpHeader(header http.Header, src []byte) ([]byte, error) {
+	keyCount, src, err := readUvarint(src)
+	if err != nil {
+		return src, err
+	}
+	for i := uint64(0); i < keyCount; i++ {
+		var key string
+		if key, src, err = readString(src); err != nil {
+			return src, err
+		}
+		var valueCount uint64
+		if valueCount, src, err = readUvarint(src); err != nil {
+			return src, err
+		}
+		if valueCount > uint64(len(src)) { // each value takes at least one byte
+			return src, ErrInvalidMessage
+		}
+		values := make([]string, valueCount)
+		for j := range values {
+			if values[j], src, err = readString(src); err != nil {
+				return src, err
+			}
+		}
+		header[key] = values
+	}
+	return src, nil
+}
+
+func resetNetHttpHeader(header http.Header) {
+	for key := range header {
+		delete(header, key)
+	}
+}
+
+// modelNetHttpRequest is a serializable representation of http.Request.
 type modelNetHttpRequest struct {
 	codec *modelCodecNetHttp
-	http.Request
+	buf   []byte
+
+	RequestID  uint64
+	Method     string
+	RequestURI string
+	Proto      string
+	Host       string
+	RemoteAddr string
+	Header     http.Header
+	Body       []byte
+	ConnInfo   ConnInfo
+	UserValues UserValues
 }
 
 func (model *modelNetHttpRequest) Release() {
@@ -38,12 +99,152 @@ func (model *modelNetHttpRequest) IsRequest() bool {
 }
 
 func (model *modelNetHttpRequest) Reset() {
-	model.Request = http.Request{}
+	model.RequestID = 0
+	model.Method = ""
+	model.RequestURI = ""
+	model.Proto = ""
+	model.Host = ""
+	model.RemoteAddr = ""
+	resetNetHttpHeader(model.Header)
+	model.Body = model.Body[:0]
+	model.ConnInfo.Reset()
+	model.UserValues.Reset()
+}
+
+func (model *modelNetHttpRequest) requestID() *uint64 {
+	return &model.RequestID
+}
+
+func (model *modelNetHttpRequest) connInfo() *ConnInfo {
+	return &model.ConnInfo
+}
+
+func (model *modelNetHttpRequest) userValues() *UserValues {
+	return &model.UserValues
+}
+
+func (model *modelNetHttpRequest) Marshal() []byte {
+	b := model.buf[:0]
+	b = appendUvarint(b, model.RequestID)
+	b = appendString(b, model.Method)
+	b = appendString(b, model.RequestURI)
+	b = appendString(b, model.Proto)
+	b = appendString(b, model.Host)
+	b = appendString(b, model.RemoteAddr)
+	b = marshalNetHttpHeader(b, model.Header)
+	b = appendBytes(b, model.Body)
+	b = model.ConnInfo.marshalTo(b)
+	b = model.UserValues.marshalTo(b)
+	model.buf = b
+	return b
+}
+
+func (model *modelNetHttpRequest) Unmarshal(b []byte) (err error) {
+	model.Reset()
+	if model.RequestID, b, err = readUvarint(b); err != nil {
+		return
+	}
+	if model.Method, b, err = readString(b); err != nil {
+		return
+	}
+	if model.RequestURI, b, err = readString(b); err != nil {
+		return
+	}
+	if model.Proto, b, err = readString(b); err != nil {
+		return
+	}
+	if model.Host, b, err = readString(b); err != nil {
+		return
+	}
+	if model.RemoteAddr, b, err = readString(b); err != nil {
+		return
+	}
+	if b, err = unmarshalNetHttpHeader(model.Header, b); err != nil {
+		return
+	}
+	if model.Body, b, err = readBytes(model.Body, b); err != nil {
+		return
+	}
+	if b, err = model.ConnInfo.unmarshalFrom(b); err != nil {
+		return
+	}
+	_, err = model.UserValues.unmarshalFrom(b)
+	return
+}
+
+// ToRequest builds a new *http.Request from the model. The body is copied, so
+// the result remains valid after the model is released.
+func (model *modelNetHttpRequest) ToRequest() (*http.Request, error) {
+	u, err := url.ParseRequestURI(model.RequestURI)
+	if err != nil {
+		return nil, err
+	}
+	req := &http.Request{
+		Method:        model.Method,
+		URL:           u,
+		Proto:         model.Proto,
+		Header:        make(http.Header, len(model.Header)),
+		Host:          model.Host,
+		RemoteAddr:    model.RemoteAddr,
+		RequestURI:    model.RequestURI,
+		ContentLength: int64(len(model.Body)),
+		Body:          http.NoBody,
+	}
+	if req.Proto == "" {
+		req.Proto = defaultNetHttpProto
+	}
+	var ok bool
+	if req.ProtoMajor, req.ProtoMinor, ok = http.ParseHTTPVersion(req.Proto); !ok {
+		req.ProtoMajor, req.ProtoMinor = 1, 1
+	}
+	for key, values := range model.Header {
+		req.Header[key] = append([]string(nil), values...)
+	}
+	if len(model.Body) > 0 {
+		req.Body = NewBytesReader(append([]byte(nil), model.Body...))
+	}
+	return req, nil
 }
 
+// FromRequest fills the model from "req". The body of "req" is consumed.
+func (model *modelNetHttpRequest) FromRequest(req *http.Request) error {
+	model.Reset()
+	model.Method = req.Method
+	model.RequestURI = req.RequestURI
+	if model.RequestURI == "" && req.URL != nil {
+		model.RequestURI = req.URL.RequestURI()
+	}
+	model.Proto = req.Proto
+	model.Host = req.Host
+	if model.Host == "" && req.URL != nil {
+		model.Host = req.URL.Host
+	}
+	model.RemoteAddr = req.RemoteAddr
+	for key, values := range req.Header {
+		model.Header[key] = append(model.Header[key][:0], values...)
+	}
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	model.Body = append(model.Body, body...)
+	return req.Body.Close()
+}
+
+// modelNetHttpResponse is a serializable representation of http.Response.
 type modelNetHttpResponse struct {
 	codec *modelCodecNetHttp
-	http.Response
+	buf   []byte
+
+	RequestID  uint64
+	StatusCode int
+	Proto      string
+	Header     http.Header
+	Body       []byte
+	UserValues UserValues
 }
 
 func (model *modelNetHttpResponse) Release() {
@@ -56,35 +257,124 @@ func (model *modelNetHttpResponse) IsResponse() bool {
 }
 
 func (model *modelNetHttpResponse) Reset() {
-	model.Response = http.Response{}
+	model.RequestID = 0
+	model.StatusCode = 0
+	model.Proto = ""
+	resetNetHttpHeader(model.Header)
+	model.Body = model.Body[:0]
+	model.UserValues.Reset()
+}
+
+func (model *modelNetHttpResponse) requestID() *uint64 {
+	return &model.RequestID
+}
+
+func (model *modelNetHttpResponse) userValues() *UserValues {
+	return &model.UserValues
+}
+
+func (model *modelNetHttpResponse) Marshal() []byte {
+	b := model.buf[:0]
+	b = appendUvarint(b, model.RequestID)
+	b = appendUvarint(b, uint64(model.StatusCode))
+	b = appendString(b, model.Proto)
+	b = marshalNetHttpHeader(b, model.Header)
+	b = appendBytes(b, model.Body)
+	b = model.UserValues.marshalTo(b)
+	model.buf = b
+	return b
+}
+
+func (model *modelNetHttpResponse) Unmarshal(b []byte) (err error) {
+	model.Reset()
+	if model.RequestID, b, err = readUvarint(b); err != nil {
+		return
+	}
+	var statusCode uint64
+	if statusCode, b, err = readUvarint(b); err != nil {
+		return
+	}
+	model.StatusCode = int(statusCode)
+	if model.Proto, b, err = readString(b); err != nil {
+		return
+	}
+	if b, err = unmarshalNetHttpHeader(model.Header, b); err != nil {
+		return
+	}
+	if model.Body, b, err = readBytes(model.Body, b); err != nil {
+		return
+	}
+	_, err = model.UserValues.unmarshalFrom(b)
+	return
+}
+
+// ToResponse builds a new *http.Response to "req" from the model. The body is
+// copied, so the result remains valid after the model is released.
+func (model *modelNetHttpResponse) ToResponse(req *http.Request) *http.Response {
+	resp := &http.Response{
+		Status:        strconv.Itoa(model.StatusCode) + " " + http.StatusText(model.StatusCode),
+		StatusCode:    model.StatusCode,
+		Proto:         model.Proto,
+		Header:        make(http.Header, len(model.Header)),
+		ContentLength: int64(len(model.Body)),
+		Body:          http.NoBody,
+		Request:       req,
+	}
+	if resp.Proto == "" {
+		resp.Proto = defaultNetHttpProto
+	}
+	var ok bool
+	if resp.ProtoMajor, resp.ProtoMinor, ok = http.ParseHTTPVersion(resp.Proto); !ok {
+		resp.ProtoMajor, resp.ProtoMinor = 1, 1
+	}
+	for key, values := range model.Header {
+		resp.Header[key] = append([]string(nil), values...)
+	}
+	if len(model.Body) > 0 {
+		resp.Body = NewBytesReader(append([]byte(nil), model.Body...))
+	}
+	return resp
+}
+
+// FromResponse fills the model from "resp". The body of "resp" is consumed.
+func (model *modelNetHttpResponse) FromResponse(resp *http.Response) error {
+	model.Reset()
+	model.StatusCode = resp.StatusCode
+	model.Proto = resp.Proto
+	for key, values := range resp.Header {
+		model.Header[key] = append(model.Header[key][:0], values...)
+	}
+	if resp.Body == nil || resp.Body == http.NoBody {
+		return nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	model.Body = append(model.Body, body...)
+	return resp.Body.Close()
 }
 
 type modelCodecNetHttp struct {
 	requestPool  *sync.Pool
 	responsePool *sync.Pool
-	buf          bytes.Buffer
 }
 
 func newModelCodecNetHttp() *modelCodecNetHttp {
 	codec := &modelCodecNetHttp{}
 	codec.requestPool = &sync.Pool{
 		New: func() interface{} {
-			r := &modelNetHttpRequest{
-				codec: codec,
+			return &modelNetHttpRequest{
+				codec:  codec,
+				Header: http.Header{},
 			}
-
-			req := r.Request
-			req.Proto = "1.1"
-			req.ProtoMajor = 1
-			req.ProtoMinor = 1
-
-			return r
 		},
 	}
 	codec.responsePool = &sync.Pool{
 		New: func() interface{} {
 			return &modelNetHttpResponse{
-				codec: codec,
+				codec:  codec,
+				Header: http.Header{},
 			}
 		},
 	}
@@ -137,46 +427,43 @@ func (r *BytesReader) Close() error {
 
 func (codec *ClientCodecNetHttp) Encode(modelI TransmittableRequest, ctx *fasthttp.RequestCtx) error {
 	src := &ctx.Request
-	dst := &modelI.(*modelNetHttpRequest).Request
-	/*dst.Method = string(ctx.Method())
-	dst.URL, _ = url.Parse(string(src.URI().FullURI()))
-	hdr := src.Header
-	hdr.*/
-
-	// Just a simple way (slow, but...)
-
-	codec.buf.Reset()
-	_, err := src.WriteTo(&codec.buf)
-	if err != nil {
-		return err
-	}
-	parsedRequest, err := http.ReadRequest(bufio.NewReader(&codec.buf))
-	*dst = *parsedRequest
+	dst := modelI.(*modelNetHttpRequest)
 
-	dst.Body = NewBytesReader(src.Body())
+	dst.Reset()
+	dst.Method = string(src.Header.Method())
+	dst.RequestURI = string(src.Header.RequestURI())
+	dst.Proto = defaultNetHttpProto
+	dst.Host = string(src.Host())
+	dst.RemoteAddr = ctx.RemoteAddr().String()
+	src.Header.VisitAll(func(key, value []byte) {
+		switch string(key) {
+		case "Host", "Content-Length": // are transferred as Host and Body
+			return
+		}
+		dst.Header.Add(string(key), string(value))
+	})
+	dst.Body = append(dst.Body, src.Body()...)
 
 	return nil
 }
 
-func (codec *ClientCodecNetHttp) Decode(ctx *fasthttp.RequestCtx, modelI TransmittableResponse) (err error) {
-	src := &modelI.(*modelNetHttpResponse).Response
+func (codec *ClientCodecNetHttp) Decode(ctx *fasthttp.RequestCtx, modelI TransmittableResponse) error {
+	src := modelI.(*modelNetHttpResponse)
 	dst := &ctx.Response
 
-	// Just a simple way (slow, but...)
-
-	codec.buf.Reset()
-	err = src.Write(&codec.buf)
-	if err != nil {
-		return err
-	}
-
 	dst.Reset()
-	err = dst.Read(bufio.NewReader(&codec.buf))
-	if err != nil {
-		return err
+	dst.SetStatusCode(src.StatusCode)
+	for key, values := range src.Header {
+		if key == "Content-Length" {
+			continue
+		}
+		for _, value := range values {
+			dst.Header.Add(key, value)
+		}
 	}
+	dst.SetBody(src.Body)
 
-	return
+	return nil
 }
 
 type ServerCodecNetHttp struct {
@@ -189,45 +476,41 @@ func newServerCodecNetHttp() *ServerCodecNetHttp {
 	return codec
 }
 
-func (codec *ServerCodecNetHttp) Decode(ctx *fasthttp.RequestCtx, modelI TransmittableRequest) (err error) {
-	src := &modelI.(*modelNetHttpRequest).Request
+func (codec *ServerCodecNetHttp) Decode(ctx *fasthttp.RequestCtx, modelI TransmittableRequest) error {
+	src := modelI.(*modelNetHttpRequest)
 	dst := &ctx.Request
 
-	// Just a simple way (slow, but...)
-
-	codec.buf.Reset()
-	err = src.Write(&codec.buf)
-	if err != nil {
-		return err
-	}
-
 	dst.Reset()
-	err = dst.Read(bufio.NewReader(&codec.buf))
-	if err != nil {
-		return err
+	dst.Header.SetMethod(src.Method)
+	dst.Header.SetRequestURI(src.RequestURI)
+	dst.Header.SetHost(src.Host)
+	for key, values := range src.Header {
+		if key == "Content-Length" {
+			continue
+		}
+		for _, value := range values {
+			dst.Header.Add(key, value)
+		}
 	}
+	dst.SetBody(src.Body)
 
-	return
+	return nil
 }
 
 func (codec *ServerCodecNetHttp) Encode(modelI TransmittableResponse, ctx *fasthttp.RequestCtx) error {
 	src := &ctx.Response
-	dst := &modelI.(*modelNetHttpResponse).Response
+	dst := modelI.(*modelNetHttpResponse)
 
-	// Just a simple way (slow, but...)
-
-	codec.buf.Reset()
-	_, err := src.WriteTo(&codec.buf)
-	if err != nil {
-		return err
-	}
-	parsedResponse, err := http.ReadResponse(bufio.NewReader(&codec.buf), nil)
-	if err != nil {
-		return err
-	}
-	*dst = *parsedResponse
-
-	dst.Body = NewBytesReader(src.Body())
+	dst.Reset()
+	dst.StatusCode = src.StatusCode()
+	dst.Proto = defaultNetHttpProto
+	src.Header.VisitAll(func(key, value []byte) {
+		if string(key) == "Content-Length" { // is transferred as Body
+			return
+		}
+		dst.Header.Add(string(key), string(value))
+	})
+	dst.Body = append(dst.Body, src.Body()...)
 
 	return nil
 }
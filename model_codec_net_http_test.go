@@ -0,0 +1,70 @@
+package fasthttpsocket
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trafficstars/fasthttp"
+)
+
+func TestDataModelNetHttp(t *testing.T) {
+	for _, serializer := range testSerializers {
+		clientCodec := newClientCodecNetHttp()
+		serverCodec := newServerCodecNetHttp()
+
+		clientCtx := &fasthttp.RequestCtx{}
+		clientCtx.Request.Header.SetMethod(`PUT`)
+		clientCtx.Request.SetRequestURI(`/path?a=b`)
+		clientCtx.Request.Header.Set(`Host`, `trafficstars.com`)
+		clientCtx.Request.Header.Set(`X-Test`, `value`)
+		clientCtx.Request.SetBodyString(`request body`)
+
+		request := clientCodec.GetRequest()
+		assert.NoError(t, clientCodec.Encode(request, clientCtx))
+		receivedRequest := serverCodec.GetRequest()
+		testTransmit(t, "go/net/http", serializer, request, receivedRequest)
+
+		httpRequest, err := receivedRequest.(*modelNetHttpRequest).ToRequest()
+		if assert.NoError(t, err, serializer) {
+			assert.Equal(t, `PUT`, httpRequest.Method, serializer)
+			assert.Equal(t, `/path`, httpRequest.URL.Path, serializer)
+			assert.Equal(t, `b`, httpRequest.URL.Query().Get(`a`), serializer)
+			assert.Equal(t, `trafficstars.com`, httpRequest.Host, serializer)
+			assert.Equal(t, `value`, httpRequest.Header.Get(`X-Test`), serializer)
+			body, err := ioutil.ReadAll(httpRequest.Body)
+			assert.NoError(t, err, serializer)
+			assert.Equal(t, `request body`, string(body), serializer)
+		}
+
+		serverCtx := &fasthttp.RequestCtx{}
+		assert.NoError(t, serverCodec.Decode(serverCtx, receivedRequest), serializer)
+		assert.Equal(t, `PUT`, string(serverCtx.Request.Header.Method()), serializer)
+		assert.Equal(t, `/path?a=b`, string(serverCtx.Request.Header.RequestURI()), serializer)
+		assert.Equal(t, `trafficstars.com`, string(serverCtx.Request.Host()), serializer)
+		assert.Equal(t, `value`, string(serverCtx.Request.Header.Peek(`X-Test`)), serializer)
+		assert.Equal(t, `request body`, string(serverCtx.Request.Body()), serializer)
+
+		response := serverCodec.GetResponse()
+		assert.NoError(t, response.(*modelNetHttpResponse).FromResponse(&http.Response{
+			StatusCode: http.StatusCreated,
+			Proto:      `HTTP/1.1`,
+			Header:     http.Header{`X-Test`: []string{`first`, `second`}},
+			Body:       ioutil.NopCloser(strings.NewReader(`response body`)),
+		}))
+		receivedResponse := clientCodec.GetResponse()
+		testTransmit(t, "go/net/http", serializer, response, receivedResponse)
+
+		assert.NoError(t, clientCodec.Decode(clientCtx, receivedResponse), serializer)
+		assert.Equal(t, http.StatusCreated, clientCtx.Response.StatusCode(), serializer)
+		assert.Equal(t, `first`, string(clientCtx.Response.Header.Peek(`X-Test`)), serializer)
+		assert.Equal(t, `response body`, string(clientCtx.Response.Body()), serializer)
+
+		request.Release()
+		receivedRequest.Release()
+		response.Release()
+		receivedResponse.Release()
+	}
+}
@@ -33,8 +33,9 @@ func (model *modelRawRequest) Marshal() []byte {
 	return model.Data
 }
 
-func (model *modelRawRequest) Unmarshal(b []byte) {
+func (model *modelRawRequest) Unmarshal(b []byte) error {
 	model.Data = b
+	return nil
 }
 
 type modelRawResponse struct {
@@ -59,8 +60,9 @@ func (model *modelRawResponse) Marshal() []byte {
 	return model.Data
 }
 
-func (model *modelRawResponse) Unmarshal(b []byte) {
+func (model *modelRawResponse) Unmarshal(b []byte) error {
 	model.Data = b
+	return nil
 }
 
 type modelCodecRaw struct {
@@ -0,0 +1,177 @@
+package fasthttpsocket
+
+import (
+	"bytes"
+	"net"
+
+	"github.com/pkg/errors"
+	"github.com/trafficstars/fasthttp"
+)
+
+// Sender is implemented by SocketClient, BalancedSocketClient and
+// SocketClientConn.
+type Sender interface {
+	SendAndReceive(ctx *fasthttp.RequestCtx) error
+}
+
+// hopByHopHeaders are meaningful only for a single connection, so they are
+// not proxied (as well as "Proxy-*" headers and headers listed in
+// "Connection").
+var hopByHopHeaders = []string{`Connection`, `Keep-Alive`, `TE`, `Upgrade`}
+
+// DefaultProxyErrorStatusCode returns 503 if the backend is unavailable (or
+// overloaded), 504 on timeouts and 502 on other errors.
+func DefaultProxyErrorStatusCode(err error) int {
+	switch errors.Cause(err) {
+	case ErrBusy, ErrNoHealthyConnections, ErrNoHealthyBackends, ErrCircuitOpen, ErrAsyncQueueFull, ErrNotStarted:
+		return fasthttp.StatusServiceUnavailable
+	}
+	if netErr, ok := errors.Cause(err).(net.Error); ok && netErr.Timeout() {
+		return fasthttp.StatusGatewayTimeout
+	}
+	return fasthttp.StatusBadGateway
+}
+
+// ProxyHandler is a reverse proxy to a socket backend, use method Handle as
+// a fasthttp.RequestHandler.
+type ProxyHandler struct {
+	Sender Sender
+	Logger Logger
+
+	// RewriteRequest (if set) is called before sending the request and
+	// RewriteResponse (if set) is called after a response is received.
+	RewriteRequest  func(ctx *fasthttp.RequestCtx)
+	RewriteResponse func(ctx *fasthttp.RequestCtx)
+
+	// SetXForwardedFor appends the remote IP to the "X-Forwarded-For"
+	// header, SetXForwardedProto sets the "X-Forwarded-Proto" header.
+	SetXForwardedFor   bool
+	SetXForwardedProto bool
+
+	// ErrorStatusCode maps an error of Sender to a status code
+	// (DefaultProxyErrorStatusCode by default).
+	ErrorStatusCode func(err error) int
+
+	// ErrorPages are bodies of error responses by status codes. The status
+	// message is sent if there's no page for the status code.
+	ErrorPages           map[int][]byte
+	ErrorPageContentType string
+}
+
+// NewProxyHandler returns a ProxyHandler with default settings.
+func NewProxyHandler(sender Sender) *ProxyHandler {
+	return &ProxyHandler{
+		Sender:               sender,
+		Logger:               dummyLogger,
+		SetXForwardedFor:     true,
+		SetXForwardedProto:   true,
+		ErrorStatusCode:      DefaultProxyErrorStatusCode,
+		ErrorPageContentType: `text/plain; charset=utf-8`,
+	}
+}
+
+// Handler returns a fasthttp.RequestHandler which proxies requests via the
+// client (see NewProxyHandler).
+func (sock *SocketClient) Handler() fasthttp.RequestHandler {
+	handler := NewProxyHandler(sock)
+	handler.Logger = sock.Logger
+	return handler.Handle
+}
+
+// Handler is the same as SocketClient.Handler
+func (sock *BalancedSocketClient) Handler() fasthttp.RequestHandler {
+	handler := NewProxyHandler(sock)
+	handler.Logger = sock.Logger
+	return handler.Handle
+}
+
+func (handler *ProxyHandler) setForwardedHeaders(ctx *fasthttp.RequestCtx) {
+	if handler.SetXForwardedFor {
+		if ip := ctx.RemoteIP(); ip != nil && !ip.IsUnspecified() {
+			forwardedFor := string(ctx.Request.Header.Peek(`X-Forwarded-For`))
+			if forwardedFor == `` {
+				forwardedFor = ip.String()
+			} else {
+				forwardedFor += `, ` + ip.String()
+			}
+			ctx.Request.Header.Set(`X-Forwarded-For`, forwardedFor)
+		}
+	}
+	if handler.SetXForwardedProto {
+		if ctx.IsTLS() {
+			ctx.Request.Header.Set(`X-Forwarded-Proto`, `https`)
+		} else {
+			ctx.Request.Header.Set(`X-Forwarded-Proto`, `http`)
+		}
+	}
+}
+
+// proxyHeader is implemented by fasthttp.RequestHeader and
+// fasthttp.ResponseHeader
+type proxyHeader interface {
+	Peek(key string) []byte
+	Del(key string)
+	VisitAll(f func(key, value []byte))
+}
+
+func removeHopByHopHeaders(header proxyHeader) {
+	var keys []string
+	for _, key := range bytes.Split(header.Peek(`Connection`), []byte(`,`)) {
+		if key = bytes.TrimSpace(key); len(key) > 0 {
+			keys = append(keys, string(key))
+		}
+	}
+	header.VisitAll(func(key, value []byte) {
+		if len(key) > len(`Proxy-`) && bytes.EqualFold(key[:len(`Proxy-`)], []byte(`Proxy-`)) {
+			keys = append(keys, string(key))
+		}
+	})
+	keys = append(keys, hopByHopHeaders...)
+	for _, key := range keys {
+		header.Del(key)
+	}
+}
+
+func (handler *ProxyHandler) writeError(ctx *fasthttp.RequestCtx, err error) {
+	statusCode := fasthttp.StatusBadGateway
+	if handler.ErrorStatusCode != nil {
+		statusCode = handler.ErrorStatusCode(err)
+	}
+
+	ctx.Response.Reset()
+	ctx.SetStatusCode(statusCode)
+	ctx.SetContentType(handler.ErrorPageContentType)
+	if page, ok := handler.ErrorPages[statusCode]; ok {
+		ctx.SetBody(page)
+	} else {
+		ctx.SetBodyString(fasthttp.StatusMessage(statusCode))
+	}
+}
+
+// Handle proxies the request of "ctx" and writes the response (or an error
+// page) into "ctx". Hop-by-hop headers are removed from the request and the
+// response.
+func (handler *ProxyHandler) Handle(ctx *fasthttp.RequestCtx) {
+	isConnectionClose := ctx.Request.Header.ConnectionClose()
+	removeHopByHopHeaders(&ctx.Request.Header)
+	handler.setForwardedHeaders(ctx)
+	if handler.RewriteRequest != nil {
+		handler.RewriteRequest(ctx)
+	}
+
+	if err := handler.Sender.SendAndReceive(ctx); err != nil {
+		if handler.Logger != nil {
+			handler.Logger.Errorf("[fasthttp-socket-proxy] %s %s: %v\n", ctx.Method(), ctx.RequestURI(), err)
+		}
+		handler.writeError(ctx, err)
+	} else {
+		removeHopByHopHeaders(&ctx.Response.Header)
+		if handler.RewriteResponse != nil {
+			handler.RewriteResponse(ctx)
+		}
+	}
+
+	if isConnectionClose { // the client asked to close its connection
+		ctx.Response.SetConnectionClose()
+	}
+}
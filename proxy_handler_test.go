@@ -0,0 +1,145 @@
+package fasthttpsocket
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/trafficstars/fasthttp"
+)
+
+type testTimeoutError struct{}
+
+func (testTimeoutError) Error() string   { return "timeout" }
+func (testTimeoutError) Timeout() bool   { return true }
+func (testTimeoutError) Temporary() bool { return true }
+
+var _ net.Error = testTimeoutError{}
+
+func TestDefaultProxyErrorStatusCode(t *testing.T) {
+	assert.Equal(t, fasthttp.StatusServiceUnavailable, DefaultProxyErrorStatusCode(ErrBusy))
+	assert.Equal(t, fasthttp.StatusServiceUnavailable, DefaultProxyErrorStatusCode(errors.Wrap(ErrCircuitOpen, "test")))
+	assert.Equal(t, fasthttp.StatusGatewayTimeout, DefaultProxyErrorStatusCode(testTimeoutError{}))
+	assert.Equal(t, fasthttp.StatusBadGateway, DefaultProxyErrorStatusCode(io.EOF))
+}
+
+// testSenderFunc is a Sender calling the function
+type testSenderFunc func(ctx *fasthttp.RequestCtx) error
+
+func (f testSenderFunc) SendAndReceive(ctx *fasthttp.RequestCtx) error {
+	return f(ctx)
+}
+
+func newTestProxyCtx() *fasthttp.RequestCtx {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Init(&fasthttp.Request{}, &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1234}, nil)
+	ctx.Request.SetRequestURI("/path")
+	return ctx
+}
+
+func TestProxyHandler(t *testing.T) {
+	handler := NewProxyHandler(testSenderFunc(func(ctx *fasthttp.RequestCtx) error {
+		assert.Equal(t, "192.168.0.1, 10.0.0.1", string(ctx.Request.Header.Peek("X-Forwarded-For")))
+		assert.Equal(t, "http", string(ctx.Request.Header.Peek("X-Forwarded-Proto")))
+		assert.Equal(t, "rewritten", string(ctx.Request.Header.Peek("X-Rewritten")))
+
+		// hop-by-hop headers are not proxied
+		for _, key := range []string{"Connection", "Keep-Alive", "TE", "Upgrade", "Proxy-Authorization", "X-Hop"} {
+			assert.Empty(t, ctx.Request.Header.Peek(key), key)
+		}
+		assert.Equal(t, "value", string(ctx.Request.Header.Peek("X-End-To-End")))
+
+		ctx.Response.Header.Set("Connection", "close")
+		ctx.Response.Header.Set("Keep-Alive", "timeout=5")
+		ctx.Response.Header.Set("Proxy-Authenticate", "Basic")
+		ctx.Response.Header.Set("X-Backend", "backend-1")
+		ctx.SetBodyString("response")
+		return nil
+	}))
+	handler.RewriteRequest = func(ctx *fasthttp.RequestCtx) {
+		ctx.Request.Header.Set("X-Rewritten", "rewritten")
+	}
+	handler.RewriteResponse = func(ctx *fasthttp.RequestCtx) {
+		ctx.Response.AppendBodyString(" (rewritten)")
+	}
+
+	ctx := newTestProxyCtx()
+	ctx.Request.Header.Set("X-Forwarded-For", "192.168.0.1")
+	ctx.Request.Header.Set("Connection", "X-Hop, keep-alive")
+	ctx.Request.Header.Set("X-Hop", "value")
+	ctx.Request.Header.Set("Keep-Alive", "timeout=5")
+	ctx.Request.Header.Set("TE", "trailers")
+	ctx.Request.Header.Set("Upgrade", "websocket")
+	ctx.Request.Header.Set("Proxy-Authorization", "Basic")
+	ctx.Request.Header.Set("X-End-To-End", "value")
+	handler.Handle(ctx)
+
+	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	assert.Equal(t, "response (rewritten)", string(ctx.Response.Body()))
+	assert.Equal(t, "backend-1", string(ctx.Response.Header.Peek("X-Backend")))
+	assert.False(t, ctx.Response.ConnectionClose(), "the connection of the client is not closed by the backend")
+	assert.Empty(t, ctx.Response.Header.Peek("Keep-Alive"))
+	assert.Empty(t, ctx.Response.Header.Peek("Proxy-Authenticate"))
+
+	// the client asked to close its connection
+	ctx = newTestProxyCtx()
+	ctx.Request.Header.SetConnectionClose()
+	handler.Sender = testSenderFunc(func(ctx *fasthttp.RequestCtx) error {
+		assert.False(t, ctx.Request.Header.ConnectionClose())
+		return nil
+	})
+	handler.Handle(ctx)
+	assert.True(t, ctx.Response.ConnectionClose())
+}
+
+func TestProxyHandlerErrors(t *testing.T) {
+	logger := &testRecordingLogger{}
+	var sendErr error
+	handler := NewProxyHandler(testSenderFunc(func(ctx *fasthttp.RequestCtx) error {
+		ctx.SetBodyString("partial response")
+		return sendErr
+	}))
+	handler.Logger = logger
+	handler.SetXForwardedFor = false
+	handler.SetXForwardedProto = false
+	handler.ErrorPages = map[int][]byte{
+		fasthttp.StatusServiceUnavailable: []byte("try again later"),
+	}
+	isResponseRewritten := false
+	handler.RewriteResponse = func(ctx *fasthttp.RequestCtx) {
+		isResponseRewritten = true
+	}
+
+	// an error page
+	sendErr = ErrBusy
+	ctx := newTestProxyCtx()
+	handler.Handle(ctx)
+	assert.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
+	assert.Equal(t, "try again later", string(ctx.Response.Body()))
+	assert.Equal(t, "text/plain; charset=utf-8", string(ctx.Response.Header.ContentType()))
+	assert.Empty(t, ctx.Request.Header.Peek("X-Forwarded-For"))
+	assert.Empty(t, ctx.Request.Header.Peek("X-Forwarded-Proto"))
+
+	// the status message is used without an error page
+	sendErr = io.EOF
+	ctx = newTestProxyCtx()
+	handler.Handle(ctx)
+	assert.Equal(t, fasthttp.StatusBadGateway, ctx.Response.StatusCode())
+	assert.Equal(t, fasthttp.StatusMessage(fasthttp.StatusBadGateway), string(ctx.Response.Body()))
+
+	// a custom mapping of errors
+	handler.ErrorStatusCode = func(err error) int {
+		return fasthttp.StatusTeapot
+	}
+	ctx = newTestProxyCtx()
+	handler.Handle(ctx)
+	assert.Equal(t, fasthttp.StatusTeapot, ctx.Response.StatusCode())
+
+	assert.False(t, isResponseRewritten)
+	if assert.Len(t, logger.Errors(), 3) {
+		assert.Contains(t, logger.Errors()[0], "GET /path")
+		assert.Contains(t, logger.Errors()[0], ErrBusy.Error())
+	}
+}
@@ -0,0 +1,104 @@
+package fasthttpsocket
+
+import (
+	"io"
+	"math"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/trafficstars/fasthttp"
+)
+
+// sendFunc sends a request and receives a response. It returns isSent == true
+// if the request could be received by the server.
+type sendFunc func(ctx *fasthttp.RequestCtx) (isSent bool, err error)
+
+// RetryPolicy defines when and how SocketClient repeats failed requests.
+type RetryPolicy struct {
+	// MaxAttempts is the maximal amount of attempts including the first one.
+	// Values less than 2 disable retries.
+	MaxAttempts int
+
+	// Backoff is the delay before the second attempt. The delay is doubled
+	// on each next attempt, but is not greater than MaxBackoff (if it's
+	// positive).
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// IsRetryable reports if a request failed with the error could be
+	// repeated. DefaultIsRetryable is used if it's nil.
+	IsRetryable func(err error) bool
+
+	// OnlyIdempotent disables repeating of requests with non-idempotent
+	// methods (like POST) if the request could be already received by the
+	// server. Requests which were not sent at all are repeated anyway.
+	OnlyIdempotent bool
+}
+
+// DefaultRetryPolicy is a reasonable policy to enable retries with (see
+// Config.RetryPolicy). Note that it repeats ErrBusy, so a request waits for a
+// free connection instead of failing immediately.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    4,
+	Backoff:        25 * time.Millisecond,
+	MaxBackoff:     500 * time.Millisecond,
+	OnlyIdempotent: true,
+}
+
+// DefaultIsRetryable returns true for errors of the connection pool and of
+// the transport (closed connections, network errors).
+func DefaultIsRetryable(err error) bool {
+	switch errors.Cause(err) {
+	case ErrBusy, ErrNoHealthyConnections, ErrNoHealthyBackends, io.EOF, io.ErrUnexpectedEOF, io.ErrClosedPipe:
+		return true
+	}
+	_, isNetErr := errors.Cause(err).(net.Error)
+	return isNetErr
+}
+
+func (policy *RetryPolicy) isRetryable(err error) bool {
+	if policy.IsRetryable == nil {
+		return DefaultIsRetryable(err)
+	}
+	return policy.IsRetryable(err)
+}
+
+// backoff returns the delay after the attempt number "attempt" (starting with 1)
+func (policy *RetryPolicy) backoff(attempt int) time.Duration {
+	delay := policy.Backoff
+	for i := 1; i < attempt; i++ {
+		if policy.MaxBackoff > 0 && delay >= policy.MaxBackoff || delay > math.MaxInt64/2 {
+			break
+		}
+		delay *= 2
+	}
+	if policy.MaxBackoff > 0 && delay > policy.MaxBackoff {
+		delay = policy.MaxBackoff
+	}
+	return delay
+}
+
+// do calls "send" until it succeeds or the policy forbids to repeat the request
+func (policy *RetryPolicy) do(ctx *fasthttp.RequestCtx, send sendFunc) error {
+	isIdempotent := isIdempotentMethod(ctx.Request.Header.Method())
+	for attempt := 1; ; attempt++ {
+		isSent, err := send(ctx)
+		switch {
+		case err == nil,
+			attempt >= policy.MaxAttempts,
+			!policy.isRetryable(err),
+			isSent && policy.OnlyIdempotent && !isIdempotent:
+			return err
+		}
+		time.Sleep(policy.backoff(attempt))
+	}
+}
+
+func isIdempotentMethod(method []byte) bool {
+	switch string(method) {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package fasthttpsocket
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trafficstars/fasthttp"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{Backoff: time.Millisecond}
+	assert.Equal(t, time.Millisecond, policy.backoff(1))
+	assert.Equal(t, 2*time.Millisecond, policy.backoff(2))
+	assert.Equal(t, 8*time.Millisecond, policy.backoff(4))
+	assert.True(t, policy.backoff(1000) > 0)
+
+	policy.MaxBackoff = 5 * time.Millisecond
+	assert.Equal(t, 4*time.Millisecond, policy.backoff(3))
+	assert.Equal(t, 5*time.Millisecond, policy.backoff(4))
+	assert.Equal(t, 5*time.Millisecond, policy.backoff(1000))
+}
+
+// testSender fails "failures" times with "err" and reports "isSent"
+type testSender struct {
+	failures int
+	err      error
+	isSent   bool
+	calls    int
+}
+
+func (sender *testSender) send(ctx *fasthttp.RequestCtx) (bool, error) {
+	sender.calls++
+	if sender.calls <= sender.failures {
+		return sender.isSent, sender.err
+	}
+	return true, nil
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	newCtx := func(method string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(method)
+		return ctx
+	}
+	policy := DefaultRetryPolicy
+	policy.Backoff = time.Microsecond
+	policy.MaxBackoff = time.Microsecond
+
+	// the zero policy doesn't repeat requests
+	sender := &testSender{failures: 1, err: ErrBusy}
+	assert.Equal(t, ErrBusy, (&RetryPolicy{}).do(newCtx("GET"), sender.send))
+	assert.Equal(t, 1, sender.calls)
+
+	// busy connections are waited for
+	sender = &testSender{failures: 2, err: ErrBusy}
+	assert.NoError(t, policy.do(newCtx("POST"), sender.send))
+	assert.Equal(t, 3, sender.calls)
+
+	// up to MaxAttempts
+	sender = &testSender{failures: 10, err: io.EOF, isSent: true}
+	assert.Equal(t, io.EOF, policy.do(newCtx("GET"), sender.send))
+	assert.Equal(t, policy.MaxAttempts, sender.calls)
+
+	// a non-retryable error
+	sender = &testSender{failures: 10, err: ErrCircuitOpen}
+	assert.Equal(t, ErrCircuitOpen, policy.do(newCtx("GET"), sender.send))
+	assert.Equal(t, 1, sender.calls)
+
+	// a POST which could be received by the server is not repeated
+	sender = &testSender{failures: 1, err: io.EOF, isSent: true}
+	assert.Equal(t, io.EOF, policy.do(newCtx("POST"), sender.send))
+	assert.Equal(t, 1, sender.calls)
+
+	// ... unless OnlyIdempotent is disabled
+	policy.OnlyIdempotent = false
+	sender = &testSender{failures: 1, err: io.EOF, isSent: true}
+	assert.NoError(t, policy.do(newCtx("POST"), sender.send))
+	assert.Equal(t, 2, sender.calls)
+}
+
+func TestSocketClientRetryPolicyIsOptIn(t *testing.T) {
+	client, err := NewSocketClient(Config{
+		Address: "fasthttp:native:unixpacket:/nonexistent.sock",
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, 0, client.RetryPolicy.MaxAttempts)
+	}
+
+	client, err = NewSocketClient(Config{
+		Address:     "fasthttp:native:unixpacket:/nonexistent.sock",
+		RetryPolicy: &DefaultRetryPolicy,
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, DefaultRetryPolicy.MaxAttempts, client.RetryPolicy.MaxAttempts)
+	}
+}
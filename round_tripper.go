@@ -0,0 +1,106 @@
+package fasthttpsocket
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/trafficstars/fasthttp"
+)
+
+var (
+	roundTripCtxPool = sync.Pool{
+		New: func() interface{} {
+			return &fasthttp.RequestCtx{}
+		},
+	}
+)
+
+// RoundTripper is a http.RoundTripper which sends requests via a SocketClient
+// (or any other Sender), so a http.Client could talk to SocketServer-s:
+//
+//	httpClient := &http.Client{Transport: fasthttpsocket.NewRoundTripper(sock)}
+//
+// Requests and responses are converted the same way as by SocketServer with
+// the "go/net/http" data model.
+type RoundTripper struct {
+	Sender Sender
+
+	codec *ServerCodecNetHttp
+}
+
+func NewRoundTripper(sender Sender) *RoundTripper {
+	return &RoundTripper{
+		Sender: sender,
+		codec:  newServerCodecNetHttp(),
+	}
+}
+
+func (rt *RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	request := rt.codec.GetRequest().(*modelNetHttpRequest)
+	defer request.Release()
+
+	if err := request.FromRequest(req); err != nil {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, err
+	}
+	if request.Method == "" {
+		request.Method = http.MethodGet
+	}
+
+	ctx := roundTripCtxPool.Get().(*fasthttp.RequestCtx)
+	if err := rt.codec.Decode(ctx, request); err != nil {
+		releaseRoundTripCtx(ctx)
+		return nil, err
+	}
+
+	if isCanceled, err := rt.sendAndReceive(req, ctx); err != nil {
+		if !isCanceled { // otherwise "ctx" is released when the request is finished
+			releaseRoundTripCtx(ctx)
+		}
+		return nil, err
+	}
+
+	response := rt.codec.GetResponse().(*modelNetHttpResponse)
+	defer response.Release()
+	err := rt.codec.Encode(response, ctx)
+	releaseRoundTripCtx(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return response.ToResponse(req), nil
+}
+
+func releaseRoundTripCtx(ctx *fasthttp.RequestCtx) {
+	ctx.Request.Reset()
+	ctx.Response.Reset()
+	ctx.ResetUserValues()
+	roundTripCtxPool.Put(ctx)
+}
+
+// sendAndReceive returns isCanceled == true and the error of req.Context() if
+// it's done before the response is received. The request can't be aborted,
+// so in this case "ctx" is released in the background when it's finished.
+func (rt *RoundTripper) sendAndReceive(req *http.Request, ctx *fasthttp.RequestCtx) (isCanceled bool, err error) {
+	done := req.Context().Done()
+	if done == nil {
+		return false, rt.Sender.SendAndReceive(ctx)
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- rt.Sender.SendAndReceive(ctx)
+	}()
+	select {
+	case err := <-result:
+		return false, err
+	case <-done:
+		go func() {
+			<-result
+			releaseRoundTripCtx(ctx)
+		}()
+		return true, req.Context().Err()
+	}
+}
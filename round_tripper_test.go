@@ -0,0 +1,80 @@
+package fasthttpsocket
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trafficstars/fasthttp"
+)
+
+func TestRoundTripper(t *testing.T) {
+	rt := NewRoundTripper(testSenderFunc(func(ctx *fasthttp.RequestCtx) error {
+		assert.Equal(t, "GET", string(ctx.Method()))
+		ctx.Response.Header.Set("X-Path", string(ctx.Path()))
+		ctx.SetStatusCode(fasthttp.StatusCreated)
+		ctx.SetBodyString("response")
+		return nil
+	}))
+	httpClient := &http.Client{Transport: rt}
+
+	resp, err := httpClient.Get("http://localhost/path")
+	if !assert.NoError(t, err) {
+		return
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "/path", resp.Header.Get("X-Path"))
+	assert.Equal(t, "response", string(body))
+}
+
+// testReleaseCloser is closed when the RequestCtx holding it as a user value
+// is released
+type testReleaseCloser chan struct{}
+
+func (closer testReleaseCloser) Close() error {
+	close(closer)
+	return nil
+}
+
+func TestRoundTripperCancel(t *testing.T) {
+	isSendingChan := make(chan struct{})
+	unblockChan := make(chan struct{})
+	releaseCloser := make(testReleaseCloser)
+	rt := NewRoundTripper(testSenderFunc(func(ctx *fasthttp.RequestCtx) error {
+		ctx.SetUserValue("closer", releaseCloser)
+		close(isSendingChan)
+		<-unblockChan
+		ctx.SetBodyString("late response")
+		return nil
+	}))
+	goroutineCount := runtime.NumGoroutine()
+
+	reqCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequest("GET", "http://localhost/path", nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	go func() {
+		<-isSendingChan
+		cancel()
+	}()
+	_, err = rt.RoundTrip(req.WithContext(reqCtx))
+	assert.Equal(t, context.Canceled, err)
+
+	// the request is finished (and "ctx" is released) in the background
+	close(unblockChan)
+	select {
+	case <-releaseCloser:
+	case <-time.After(time.Second):
+		t.Error("the RequestCtx is not released")
+	}
+	assert.True(t, waitFor(func() bool { return runtime.NumGoroutine() <= goroutineCount }))
+}
@@ -0,0 +1,96 @@
+package fasthttpsocket
+
+import (
+	"net"
+	"time"
+
+	"github.com/trafficstars/fasthttp"
+)
+
+const (
+	// rejectTimeout limits waiting for the first request of a rejected
+	// connection (and sending the response to it)
+	rejectTimeout = time.Second
+)
+
+// DefaultOverloadResponse responds with "503 Service Unavailable" and asks
+// the client to retry after a second.
+func DefaultOverloadResponse(ctx *fasthttp.RequestCtx) {
+	ctx.Error(`Server is overloaded`, fasthttp.StatusServiceUnavailable)
+	ctx.Response.Header.Set(`Retry-After`, `1`)
+}
+
+// slots is a semaphore, a nil slots has no limit
+type slots chan struct{}
+
+func newSlots(limit int) slots {
+	if limit <= 0 {
+		return nil
+	}
+	return make(slots, limit)
+}
+
+// acquire takes a slot waiting for it up to "wait". Returns false if there's
+// no free slot.
+func (s slots) acquire(wait time.Duration) bool {
+	if s == nil {
+		return true
+	}
+	select {
+	case s <- struct{}{}:
+		return true
+	default:
+	}
+	if wait <= 0 {
+		return false
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case s <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (s slots) release() {
+	if s == nil {
+		return
+	}
+	<-s
+}
+
+// newResponseCtx returns a new ctx with the response of "respond" (for
+// example, to send it instead of the response of the handler).
+func newResponseCtx(respond fasthttp.RequestHandler) *fasthttp.RequestCtx {
+	ctx := &fasthttp.RequestCtx{}
+	respond(ctx)
+	return ctx
+}
+
+// rejectSocketConnection answers the first request of "conn" with the
+// response of OverloadResponse and closes "conn".
+func (sock *SocketServer) rejectSocketConnection(conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(rejectTimeout))
+
+	msg := NewMessanger(conn)
+	encoder := sock.NewEncoderFunc(msg)
+	decoder := sock.NewDecoderFunc(msg)
+
+	modelCodec := sock.DataModel.GetServerCodec()
+	request := modelCodec.GetRequest()
+	defer request.Release()
+	response := modelCodec.GetResponse()
+	defer response.Release()
+
+	if err := decoder.Decode(request); err != nil {
+		return
+	}
+	if err := sock.encodeResponse(newResponseCtx(sock.OverloadResponse), modelCodec, request, response); err != nil {
+		return
+	}
+	_ = encoder.Encode(response)
+}
@@ -0,0 +1,125 @@
+package fasthttpsocket
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trafficstars/fasthttp"
+)
+
+func TestSlots(t *testing.T) {
+	assert.True(t, newSlots(0).acquire(0))
+
+	s := newSlots(1)
+	assert.True(t, s.acquire(0))
+	assert.False(t, s.acquire(0))
+	assert.False(t, s.acquire(time.Millisecond))
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		s.release()
+	}()
+	assert.True(t, s.acquire(time.Second))
+}
+
+func newTestLimitsClient(t *testing.T, address string) *SocketClient {
+	client, err := NewSocketClient(Config{Address: address})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	if !assert.NoError(t, client.Start(1)) {
+		t.FailNow()
+	}
+	return client
+}
+
+func TestServerMaxConns(t *testing.T) {
+	address := "fasthttp:native:unixpacket:" + filepath.Join(t.TempDir(), "max-conns.sock")
+	srv, logger := startTestTimeoutServer(t, address, func(ctx *fasthttp.RequestCtx) {
+		ctx.SetBodyString("ok")
+	}, Config{
+		MaxConns:     1,
+		MaxQueueWait: 100 * time.Millisecond,
+	})
+	defer srv.Stop()
+
+	// the first connection takes the only slot
+	client := newTestLimitsClient(t, address)
+	defer client.Close()
+	ctx := &fasthttp.RequestCtx{}
+	assert.NoError(t, client.SendAndReceive(ctx))
+	assert.Equal(t, "ok", string(ctx.Response.Body()))
+
+	// other connections wait for a slot at once (accepting isn't blocked)
+	// and get the overload response
+	startTime := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client := newTestLimitsClient(t, address)
+			defer client.Close()
+			ctx := &fasthttp.RequestCtx{}
+			if assert.NoError(t, client.SendAndReceive(ctx)) {
+				assert.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
+				assert.Equal(t, "1", string(ctx.Response.Header.Peek("Retry-After")))
+			}
+		}()
+	}
+	wg.Wait()
+	assert.True(t, time.Since(startTime) < 250*time.Millisecond, time.Since(startTime))
+
+	// the first connection is still served
+	ctx = &fasthttp.RequestCtx{}
+	assert.NoError(t, client.SendAndReceive(ctx))
+	assert.Equal(t, "ok", string(ctx.Response.Body()))
+	assert.Empty(t, logger.Errors())
+}
+
+func TestServerMaxConcurrentRequests(t *testing.T) {
+	address := "fasthttp:native:unixpacket:" + filepath.Join(t.TempDir(), "max-requests.sock")
+	isSlowStarted := make(chan struct{})
+	srv, logger := startTestTimeoutServer(t, address, func(ctx *fasthttp.RequestCtx) {
+		if string(ctx.Path()) == "/slow" {
+			close(isSlowStarted)
+			time.Sleep(100 * time.Millisecond)
+		}
+		ctx.SetBodyString("ok")
+	}, Config{
+		MaxConcurrentRequests: 1,
+	})
+	defer srv.Stop()
+
+	client, err := NewSocketClient(Config{Address: address})
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.NoError(t, client.Start(2)) {
+		return
+	}
+	defer client.Close()
+
+	slowResult := make(chan error, 1)
+	slowCtx := &fasthttp.RequestCtx{}
+	slowCtx.Request.SetRequestURI("/slow")
+	go func() {
+		slowResult <- client.SendAndReceive(slowCtx)
+	}()
+	<-isSlowStarted
+
+	ctx := &fasthttp.RequestCtx{}
+	assert.NoError(t, client.SendAndReceive(ctx))
+	assert.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
+	assert.Equal(t, "Server is overloaded", string(ctx.Response.Body()))
+
+	assert.NoError(t, <-slowResult)
+	assert.Equal(t, "ok", string(slowCtx.Response.Body()))
+	ctx = &fasthttp.RequestCtx{}
+	assert.NoError(t, client.SendAndReceive(ctx))
+	assert.Equal(t, "ok", string(ctx.Response.Body()))
+	assert.Empty(t, logger.Errors())
+}
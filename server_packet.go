@@ -0,0 +1,256 @@
+package fasthttpsocket
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	maxDatagramSize     = 65536
+	packetPeerQueueSize = 64
+
+	defaultPacketPeerIdleTimeout = time.Minute
+)
+
+// ServePacket handles requests received on "packetConn" (a "unixgram" or
+// "udp" socket). Datagrams of every peer address are handled as a separate
+// connection (MaxConns limits the amount of peers, but a new peer doesn't
+// wait for a slot: its datagram gets the response of OverloadResponse).
+// There's no way to know that a peer is gone, so a peer is forgotten after
+// IdleTimeout (or after defaultPacketPeerIdleTimeout if it's not set).
+// Clients of a "unixgram" socket should be bound to an address, otherwise
+// there's no way to respond to them. Returns ErrServerClosed after Stop or
+// another error of ReadFrom.
+func (sock *SocketServer) ServePacket(packetConn net.PacketConn) error {
+	sock.init()
+	logger := sock.Logger
+
+	if !sock.trackListener(packetConn, true) {
+		return ErrServerClosed
+	}
+	defer sock.trackListener(packetConn, false)
+
+	var peersLocker sync.Mutex
+	peers := map[string]*packetPeerConn{}
+	defer func() {
+		var closers []io.Closer
+		peersLocker.Lock()
+		for _, peer := range peers {
+			closers = append(closers, peer)
+		}
+		peersLocker.Unlock()
+		for _, closer := range closers {
+			_ = closer.Close()
+		}
+	}()
+
+	// IdleTimeout (if set) is applied by handleSocketConnection
+	var peerIdleTimeout time.Duration
+	if sock.IdleTimeout <= 0 {
+		peerIdleTimeout = defaultPacketPeerIdleTimeout
+	}
+
+	buf := make([]byte, maxDatagramSize)
+	var delay time.Duration
+	for {
+		n, addr, err := packetConn.ReadFrom(buf)
+		if err != nil {
+			if sock.isStopped() {
+				return ErrServerClosed
+			}
+			if isTemporaryError(err) {
+				delay = sock.waitAcceptRetry(err, delay)
+				continue
+			}
+			return err
+		}
+		delay = 0
+
+		if addr == nil {
+			logger.Errorf("[fasthttp-socket] got a datagram from an unbound socket, dropping it\n")
+			continue
+		}
+		key := addr.String()
+
+		peersLocker.Lock()
+		peer := peers[key]
+		peersLocker.Unlock()
+
+		if peer == nil || peer.isClosed() {
+			if !sock.connSlots.acquire(0) { // waiting would block all peers
+				logger.Print(`[fasthttp-socket] too many peers (`, sock.MaxConns, `), rejecting a datagram of `, addr)
+				peer = newPacketPeerConn(packetConn, addr, 0, func(peer *packetPeerConn) {})
+				peer.push(buf[:n])
+				go sock.rejectSocketConnection(peer)
+				continue
+			}
+			peer = newPacketPeerConn(packetConn, addr, peerIdleTimeout, func(peer *packetPeerConn) {
+				peersLocker.Lock()
+				if peers[key] == peer {
+					delete(peers, key)
+				}
+				peersLocker.Unlock()
+			})
+			if !sock.trackConn(peer, true) { // is stopped
+				sock.connSlots.release()
+				return ErrServerClosed
+			}
+			peersLocker.Lock()
+			peers[key] = peer
+			peersLocker.Unlock()
+
+			go func(peer *packetPeerConn) {
+				defer sock.connSlots.release()
+				defer sock.trackConn(peer, false)
+				sock.handleSocketConnection(peer)
+			}(peer)
+		}
+
+		if !peer.push(buf[:n]) {
+			logger.Errorf("[fasthttp-socket] the queue of %v is full, dropping a datagram\n", addr)
+		}
+	}
+}
+
+// packetPeerConn is a net.Conn of a peer of a net.PacketConn: it reads
+// datagrams received from the peer (one datagram per Read) and writes
+// datagrams to the peer. If there's no read deadline, Read returns io.EOF
+// after "idleTimeout" (if set) without datagrams.
+type packetPeerConn struct {
+	packetConn  net.PacketConn
+	addr        net.Addr
+	idleTimeout time.Duration
+	datagrams   chan []byte
+	closed      chan struct{}
+	closeOnce   sync.Once
+	onClose     func(peer *packetPeerConn)
+
+	deadlineLocker  sync.Mutex
+	readDeadline    time.Time
+	deadlineChanged chan struct{} // wakes up a blocked Read
+}
+
+func newPacketPeerConn(
+	packetConn net.PacketConn,
+	addr net.Addr,
+	idleTimeout time.Duration,
+	onClose func(peer *packetPeerConn),
+) *packetPeerConn {
+	return &packetPeerConn{
+		packetConn:  packetConn,
+		addr:        addr,
+		idleTimeout: idleTimeout,
+		datagrams:   make(chan []byte, packetPeerQueueSize),
+		closed:      make(chan struct{}),
+		onClose:     onClose,
+
+		deadlineChanged: make(chan struct{}),
+	}
+}
+
+// push queues a copy of the datagram. Returns false if the queue is full.
+func (c *packetPeerConn) push(datagram []byte) bool {
+	select {
+	case c.datagrams <- append([]byte(nil), datagram...):
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *packetPeerConn) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *packetPeerConn) Read(b []byte) (int, error) {
+	for {
+		n, isDeadlineChanged, err := c.readBeforeDeadlineChange(b)
+		if !isDeadlineChanged {
+			return n, err
+		}
+	}
+}
+
+// readBeforeDeadlineChange returns isDeadlineChanged == true if the read
+// deadline was changed while waiting for a datagram (like net.Conn, a
+// blocked Read should follow the new deadline)
+func (c *packetPeerConn) readBeforeDeadlineChange(b []byte) (n int, isDeadlineChanged bool, err error) {
+	if c.isClosed() {
+		return 0, false, io.EOF
+	}
+
+	c.deadlineLocker.Lock()
+	deadline, deadlineChanged := c.readDeadline, c.deadlineChanged
+	c.deadlineLocker.Unlock()
+	timeout, timeoutErr := c.idleTimeout, io.EOF // the peer is gone
+	if !deadline.IsZero() {
+		timeout, timeoutErr = time.Until(deadline), os.ErrDeadlineExceeded
+		if timeout <= 0 {
+			return 0, false, timeoutErr
+		}
+	}
+
+	var timeoutChan <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutChan = timer.C
+	}
+
+	select {
+	case datagram := <-c.datagrams:
+		return copy(b, datagram), false, nil
+	case <-c.closed:
+		return 0, false, io.EOF
+	case <-timeoutChan:
+		return 0, false, timeoutErr
+	case <-deadlineChanged:
+		return 0, true, nil
+	}
+}
+
+func (c *packetPeerConn) Write(b []byte) (int, error) {
+	return c.packetConn.WriteTo(b, c.addr)
+}
+
+func (c *packetPeerConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.onClose(c)
+	})
+	return nil
+}
+
+func (c *packetPeerConn) LocalAddr() net.Addr {
+	return c.packetConn.LocalAddr()
+}
+
+func (c *packetPeerConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func (c *packetPeerConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *packetPeerConn) SetReadDeadline(t time.Time) error {
+	c.deadlineLocker.Lock()
+	c.readDeadline = t
+	close(c.deadlineChanged)
+	c.deadlineChanged = make(chan struct{})
+	c.deadlineLocker.Unlock()
+	return nil
+}
+
+// SetWriteDeadline does nothing: the socket is shared by all peers
+func (c *packetPeerConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -0,0 +1,196 @@
+package fasthttpsocket
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/trafficstars/fasthttp"
+)
+
+const (
+	handlerContextUserValueKey = `fasthttpsocket.HandlerContext`
+)
+
+// HandlerContext returns the context of a request handled by SocketServer.
+// It's done when the HandlerTimeout is exceeded (a handler should stop in
+// this case, the response is already sent). Returns context.Background() if
+// there's no HandlerTimeout.
+func HandlerContext(ctx *fasthttp.RequestCtx) context.Context {
+	if handlerCtx, ok := ctx.UserValue(handlerContextUserValueKey).(context.Context); ok {
+		return handlerCtx
+	}
+	return context.Background()
+}
+
+// DefaultHandlerTimeoutResponse responds with "503 Service Unavailable"
+func DefaultHandlerTimeoutResponse(ctx *fasthttp.RequestCtx) {
+	ctx.Error(`Handler timeout`, fasthttp.StatusServiceUnavailable)
+}
+
+// timeoutReadWriter sets deadlines of "conn" on reading from and writing to
+// its Messanger: IdleTimeout is applied while waiting for a request and
+// ReadTimeout is applied after the first byte of the request is read.
+type timeoutReadWriter struct {
+	io.ReadWriter
+	conn net.Conn
+
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	idleTimeout  time.Duration
+
+	// the connection is not idle while requests are handled concurrently
+	// (see handleSocketConnectionConcurrently), so the state is locked
+	locker   sync.Mutex
+	isIdle   bool
+	inFlight int
+}
+
+func newTimeoutReadWriter(rw io.ReadWriter, conn net.Conn, readTimeout, writeTimeout, idleTimeout time.Duration) io.ReadWriter {
+	if readTimeout <= 0 && writeTimeout <= 0 && idleTimeout <= 0 {
+		return rw
+	}
+	return &timeoutReadWriter{
+		ReadWriter:   rw,
+		conn:         conn,
+		readTimeout:  readTimeout,
+		writeTimeout: writeTimeout,
+		idleTimeout:  idleTimeout,
+	}
+}
+
+// startIdle should be called before waiting for the next request
+func (rw *timeoutReadWriter) startIdle() {
+	rw.locker.Lock()
+	defer rw.locker.Unlock()
+	rw.isIdle = true
+	rw.setIdleDeadline()
+}
+
+// setIdleDeadline should be called with locked "rw.locker"
+func (rw *timeoutReadWriter) setIdleDeadline() {
+	if rw.inFlight > 0 { // is set when the last request is handled
+		_ = rw.conn.SetReadDeadline(time.Time{})
+		return
+	}
+	timeout := rw.idleTimeout
+	if timeout <= 0 {
+		timeout = rw.readTimeout
+	}
+	if timeout > 0 {
+		_ = rw.conn.SetReadDeadline(time.Now().Add(timeout))
+	}
+}
+
+// startHandling should be called when a request is passed to a concurrent
+// handler
+func (rw *timeoutReadWriter) startHandling() {
+	rw.locker.Lock()
+	defer rw.locker.Unlock()
+	rw.inFlight++
+}
+
+// finishHandling should be called when the response of a concurrent handler
+// is sent
+func (rw *timeoutReadWriter) finishHandling() {
+	rw.locker.Lock()
+	defer rw.locker.Unlock()
+	rw.inFlight--
+	if rw.inFlight == 0 && rw.isIdle {
+		rw.setIdleDeadline()
+	}
+}
+
+// isIdleTimeout returns true if "err" is a timeout of waiting for the next
+// request (it's an expected way to close the connection)
+func (rw *timeoutReadWriter) isIdleTimeout(err error) bool {
+	netErr, ok := errors.Cause(err).(net.Error)
+	if !ok || !netErr.Timeout() {
+		return false
+	}
+	rw.locker.Lock()
+	defer rw.locker.Unlock()
+	return rw.isIdle
+}
+
+func (rw *timeoutReadWriter) Read(b []byte) (int, error) {
+	n, err := rw.ReadWriter.Read(b)
+	if n > 0 {
+		rw.locker.Lock()
+		if rw.isIdle {
+			rw.isIdle = false
+			switch {
+			case rw.readTimeout > 0:
+				_ = rw.conn.SetReadDeadline(time.Now().Add(rw.readTimeout))
+			case rw.idleTimeout > 0:
+				_ = rw.conn.SetReadDeadline(time.Time{})
+			}
+		}
+		rw.locker.Unlock()
+	}
+	return n, err
+}
+
+func (rw *timeoutReadWriter) Write(b []byte) (int, error) {
+	if rw.writeTimeout > 0 {
+		_ = rw.conn.SetWriteDeadline(time.Now().Add(rw.writeTimeout))
+	}
+	return rw.ReadWriter.Write(b)
+}
+
+// startIdle calls timeoutReadWriter.startIdle if timeouts are used
+func startIdle(rw io.ReadWriter) {
+	if rw, ok := rw.(*timeoutReadWriter); ok {
+		rw.startIdle()
+	}
+}
+
+// startHandling calls timeoutReadWriter.startHandling if timeouts are used
+func startHandling(rw io.ReadWriter) {
+	if rw, ok := rw.(*timeoutReadWriter); ok {
+		rw.startHandling()
+	}
+}
+
+// finishHandling calls timeoutReadWriter.finishHandling if timeouts are used
+func finishHandling(rw io.ReadWriter) {
+	if rw, ok := rw.(*timeoutReadWriter); ok {
+		rw.finishHandling()
+	}
+}
+
+// isIdleTimeout calls timeoutReadWriter.isIdleTimeout if timeouts are used
+func isIdleTimeout(rw io.ReadWriter, err error) bool {
+	if rw, ok := rw.(*timeoutReadWriter); ok {
+		return rw.isIdleTimeout(err)
+	}
+	return false
+}
+
+// handleRequestWithTimeout returns isTimedOut == true if the handler didn't
+// finish within HandlerTimeout. The handler continues to use "ctx" in this
+// case, so it shouldn't be reused.
+func (sock *SocketServer) handleRequestWithTimeout(ctx *fasthttp.RequestCtx) (isTimedOut bool, err error) {
+	if sock.HandlerTimeout <= 0 {
+		return false, sock.handleRequest(ctx)
+	}
+
+	handlerCtx, cancel := context.WithTimeout(context.Background(), sock.HandlerTimeout)
+	defer cancel()
+	ctx.SetUserValue(handlerContextUserValueKey, handlerCtx)
+
+	result := make(chan error, 1)
+	go func() {
+		result <- sock.handleRequest(ctx)
+	}()
+
+	select {
+	case err := <-result:
+		return false, err
+	case <-handlerCtx.Done():
+		return true, nil
+	}
+}
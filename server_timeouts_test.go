@@ -0,0 +1,163 @@
+package fasthttpsocket
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trafficstars/fasthttp"
+)
+
+// startTestTimeoutServer starts a server with the timeouts of "cfg" and a
+// recording logger
+func startTestTimeoutServer(t *testing.T, address string, handler fasthttp.RequestHandler, cfg Config) (*SocketServer, *testRecordingLogger) {
+	logger := &testRecordingLogger{}
+	cfg.Address = address
+	cfg.UnixSocketPermissions = 0700
+	cfg.Logger = logger
+	srv, err := NewSocketServerWithRequestHandler(handler, cfg)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	if !assert.NoError(t, srv.Start()) {
+		t.FailNow()
+	}
+	return srv, logger
+}
+
+// waitForClose returns true if the server closes "conn" within a second
+func waitForClose(conn net.Conn) bool {
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := io.Copy(ioutil.Discard, conn)
+	netErr, ok := err.(net.Error)
+	return err == nil || !(ok && netErr.Timeout())
+}
+
+func TestServerIdleTimeout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "idle.sock")
+	srv, logger := startTestTimeoutServer(t, "fasthttp:native:unixpacket:"+path, func(ctx *fasthttp.RequestCtx) {}, Config{
+		IdleTimeout: 20 * time.Millisecond,
+	})
+	defer srv.Stop()
+
+	conn, err := net.Dial("unixpacket", path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	assert.True(t, waitForClose(conn))
+	assert.Empty(t, logger.Errors(), "an idle connection is closed silently")
+}
+
+func TestServerReadTimeout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "read.sock")
+	srv, logger := startTestTimeoutServer(t, "http1:native:unix:"+path, func(ctx *fasthttp.RequestCtx) {}, Config{
+		ReadTimeout: 20 * time.Millisecond,
+	})
+	defer srv.Stop()
+
+	conn, err := net.Dial("unix", path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n")) // an incomplete request
+	assert.NoError(t, err)
+	assert.True(t, waitForClose(conn))
+	assert.True(t, waitFor(func() bool { return len(logger.Errors()) == 1 }))
+}
+
+func TestServerWriteTimeout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "write.sock")
+	srv, logger := startTestTimeoutServer(t, "http1:native:unix:"+path, func(ctx *fasthttp.RequestCtx) {
+		ctx.SetBody(bytes.Repeat([]byte("a"), 16*1024*1024))
+	}, Config{
+		WriteTimeout: 20 * time.Millisecond,
+	})
+	defer srv.Stop()
+
+	conn, err := net.Dial("unix", path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	assert.NoError(t, err)
+
+	// the response isn't read, so it doesn't fit into the socket buffer
+	assert.True(t, waitFor(func() bool { return len(logger.Errors()) == 1 }))
+	if errs := logger.Errors(); len(errs) > 0 {
+		assert.True(t, strings.Contains(errs[0], "unable to send a message"), errs[0])
+	}
+}
+
+func TestServerHandlerTimeout(t *testing.T) {
+	address := "fasthttp:native:unixpacket:" + filepath.Join(t.TempDir(), "handler.sock")
+	srv, logger := startTestTimeoutServer(t, address, func(ctx *fasthttp.RequestCtx) {
+		if string(ctx.Path()) == "/slow" {
+			<-HandlerContext(ctx).Done()
+		}
+		ctx.SetBodyString("ok")
+	}, Config{
+		HandlerTimeout: 20 * time.Millisecond,
+	})
+	defer srv.Stop()
+
+	client, err := NewSocketClient(Config{Address: address})
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.NoError(t, client.Start(1)) {
+		return
+	}
+	defer client.Close()
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/slow")
+	assert.NoError(t, client.SendAndReceive(ctx))
+	assert.Equal(t, fasthttp.StatusServiceUnavailable, ctx.Response.StatusCode())
+	assert.Equal(t, "Handler timeout", string(ctx.Response.Body()))
+	assert.Len(t, logger.Errors(), 1)
+
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/fast")
+	assert.NoError(t, client.SendAndReceive(ctx))
+	assert.Equal(t, "ok", string(ctx.Response.Body()))
+}
+
+func TestServerIdleTimeoutConcurrently(t *testing.T) {
+	address := "fasthttp:native:unixpacket:" + filepath.Join(t.TempDir(), "idle-concurrently.sock")
+	srv, logger := startTestTimeoutServer(t, address, func(ctx *fasthttp.RequestCtx) {
+		time.Sleep(100 * time.Millisecond)
+		ctx.SetBodyString("ok")
+	}, Config{
+		IdleTimeout:     20 * time.Millisecond,
+		ConnConcurrency: 4,
+	})
+	defer srv.Stop()
+
+	client, err := NewSocketClient(Config{Address: address})
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.NoError(t, client.Start(1)) {
+		return
+	}
+	defer client.Close()
+
+	// the connection is not idle while the handlers are in progress
+	ctxs := []*fasthttp.RequestCtx{{}, {}}
+	for _, err := range client.SendBatch(ctxs) {
+		assert.NoError(t, err)
+	}
+	ctx := &fasthttp.RequestCtx{}
+	assert.NoError(t, client.SendAndReceive(ctx))
+	assert.Equal(t, "ok", string(ctx.Response.Body()))
+	assert.Empty(t, logger.Errors())
+}
@@ -1,6 +1,7 @@
 package fasthttpsocket
 
 import (
+	"bufio"
 	"encoding/gob"
 	"encoding/json"
 	"io"
@@ -22,6 +23,8 @@ var (
 	ErrNotImplemented      = errors.New(`[fasthttp-socket] not implemented, yet`)
 	ErrNoNativeMarshaler   = errors.New(`[fasthttp-socket] selected datamodel doesn't have any native marshaler`)
 	ErrNoNativeUnmarshaler = errors.New(`[fasthttp-socket] selected datamodel doesn't have any native unmarshaler`)
+	ErrInvalidMessage      = errors.New(`[fasthttp-socket] invalid message`)
+	ErrNativeOnly          = errors.New(`[fasthttp-socket] selected datamodel supports only the "native" serializer`)
 )
 
 type Family int
@@ -52,6 +55,15 @@ func (f Family) String() string {
 	}
 }
 
+// isStream returns true for families without message boundaries
+func (f Family) isStream() bool {
+	switch f {
+	case FamilyUnixStream, FamilyTCP:
+		return true
+	}
+	return false
+}
+
 type serializerType int
 
 const (
@@ -65,14 +77,31 @@ type dataModel int
 const (
 	dataModelRaw = iota
 	dataModelNetHttp
+	dataModelFastHttp
+	dataModelFastCGI
+	dataModelHttp1
 )
 
+// isWireProtocol returns true if the data model defines the wire format by
+// itself (so it could be used only with the "native" serializer).
+func (dataModel dataModel) isWireProtocol() bool {
+	switch dataModel {
+	case dataModelFastCGI, dataModelHttp1:
+		return true
+	}
+	return false
+}
+
 func (dataModel dataModel) GetServerCodec() ServerCodec {
 	switch dataModel {
 	case dataModelRaw:
 		return newServerCodecRaw()
 	case dataModelNetHttp:
 		return newServerCodecNetHttp()
+	case dataModelFastHttp:
+		return newServerCodecFastHttp()
+	case dataModelHttp1:
+		return newServerCodecHttp1()
 	}
 	return nil
 }
@@ -83,6 +112,12 @@ func (dataModel dataModel) GetClientCodec() ClientCodec {
 		return newClientCodecRaw()
 	case dataModelNetHttp:
 		return newClientCodecNetHttp()
+	case dataModelFastHttp:
+		return newClientCodecFastHttp()
+	case dataModelFastCGI:
+		return newClientCodecFastCGI()
+	case dataModelHttp1:
+		return newClientCodecHttp1()
 	}
 	return nil
 }
@@ -123,6 +158,12 @@ func parseConfig(cfg *Config) (
 		dataModel = dataModelRaw
 	case "go/net/http":
 		dataModel = dataModelNetHttp
+	case "fasthttp":
+		dataModel = dataModelFastHttp
+	case "fastcgi":
+		dataModel = dataModelFastCGI
+	case "http1":
+		dataModel = dataModelHttp1
 	default:
 		err = errors.Wrap(ErrUnknownDataModel, words[0])
 		return
@@ -140,6 +181,10 @@ func parseConfig(cfg *Config) (
 		err = errors.Wrap(ErrUnknownSerializer, words[1])
 		return
 	}
+	if dataModel.isWireProtocol() && serializerType != serializerTypeNative {
+		err = errors.Wrap(ErrNativeOnly, words[0])
+		return
+	}
 
 	switch words[2] {
 	case "unix":
@@ -200,6 +245,36 @@ type Unmarshaler interface {
 	Unmarshal([]byte)
 }
 
+// CheckedUnmarshaler is the same as Unmarshaler, but may reject an invalid
+// message (the error is returned by Decode).
+type CheckedUnmarshaler interface {
+	Unmarshal([]byte) error
+}
+
+// StreamUnmarshaler is implemented by models which know where their messages
+// end in a stream (so they could be read from stream sockets).
+type StreamUnmarshaler interface {
+	UnmarshalFrom(*bufio.Reader) error
+}
+
+// connectionCloser is implemented by models which may ask to close the
+// connection after the current message (like "Connection: close" in HTTP/1.1).
+type connectionCloser interface {
+	ConnectionClose() bool
+}
+
+// reconnectListener is implemented by client codecs which keep a state of
+// the connection (like requests waiting for responses)
+type reconnectListener interface {
+	onReconnect()
+}
+
+// requestIDCarrier is implemented by models which carry request IDs, so
+// responses could be sent in another order than requests were received.
+type requestIDCarrier interface {
+	requestID() *uint64
+}
+
 type dummyEncoder struct {
 	w io.Writer
 }
@@ -220,6 +295,7 @@ func (enc *dummyEncoder) Encode(e interface{}) error {
 
 type dummyDecoder struct {
 	r   io.Reader
+	br  *bufio.Reader
 	buf [dummyDecoderBufferSize]byte
 }
 
@@ -228,15 +304,27 @@ func newDummyDecoder(r io.Reader) *dummyDecoder {
 }
 
 func (dec *dummyDecoder) Decode(e interface{}) error {
-	obj, ok := e.(Unmarshaler)
-	if !ok {
-		return ErrNoNativeUnmarshaler
+	if obj, ok := e.(StreamUnmarshaler); ok {
+		if dec.br == nil {
+			dec.br = bufio.NewReaderSize(dec.r, dummyDecoderBufferSize)
+		}
+		return obj.UnmarshalFrom(dec.br)
 	}
 
-	n, err := dec.r.Read(dec.buf[:])
-	if err != nil {
-		return err
+	switch obj := e.(type) {
+	case CheckedUnmarshaler:
+		n, err := dec.r.Read(dec.buf[:])
+		if err != nil {
+			return err
+		}
+		return obj.Unmarshal(dec.buf[:n])
+	case Unmarshaler:
+		n, err := dec.r.Read(dec.buf[:])
+		if err != nil {
+			return err
+		}
+		obj.Unmarshal(dec.buf[:n])
+		return nil
 	}
-	obj.Unmarshal(dec.buf[:n])
-	return err
+	return ErrNoNativeUnmarshaler
 }
@@ -0,0 +1,470 @@
+package fasthttpsocket
+
+import (
+	"bytes"
+	"hash/fnv"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/trafficstars/fasthttp"
+	"github.com/trafficstars/spinlock"
+)
+
+var (
+	ErrNoAddresses       = errors.New(`[fasthttp-socket-balancer] no addresses`)
+	ErrNoHealthyBackends = errors.New(`[fasthttp-socket-balancer] no healthy backends`)
+	ErrBackendExists     = errors.New(`[fasthttp-socket-balancer] backend already exists`)
+	ErrUnknownBackend    = errors.New(`[fasthttp-socket-balancer] unknown backend`)
+	ErrNoBalancingKey    = errors.New(`[fasthttp-socket-balancer] BalancingConsistentHash requires BalancingKeyFunc`)
+)
+
+const (
+	defaultEjectFailures = 5
+	defaultEjectTimeout  = 10 * time.Second
+)
+
+type BalancingStrategy int
+
+const (
+	// BalancingRoundRobin sends requests to backends in turn
+	BalancingRoundRobin = BalancingStrategy(iota)
+
+	// BalancingLeastInFlight sends a request to the backend with the
+	// least amount of requests in progress
+	BalancingLeastInFlight
+
+	// BalancingRandomTwoChoices picks two random backends and sends a
+	// request to the one with less requests in progress
+	BalancingRandomTwoChoices
+
+	// BalancingConsistentHash sends requests with the same key (see
+	// BalancingKeyFunc) to the same backend. It uses rendezvous hashing, so
+	// adding or removing a backend remaps only the keys of that backend.
+	BalancingConsistentHash
+)
+
+func (strategy BalancingStrategy) String() string {
+	switch strategy {
+	case BalancingRoundRobin:
+		return `round-robin`
+	case BalancingLeastInFlight:
+		return `least-in-flight`
+	case BalancingRandomTwoChoices:
+		return `random-two-choices`
+	case BalancingConsistentHash:
+		return `consistent-hash`
+	default:
+		return ``
+	}
+}
+
+// BalancingKeyFunc returns the key used by BalancingConsistentHash. Requests
+// with an empty key are balanced in round-robin manner.
+type BalancingKeyFunc func(ctx *fasthttp.RequestCtx) []byte
+
+// HeaderKey returns a BalancingKeyFunc which uses the value of a request header
+func HeaderKey(name string) BalancingKeyFunc {
+	return func(ctx *fasthttp.RequestCtx) []byte {
+		return ctx.Request.Header.Peek(name)
+	}
+}
+
+// CookieKey returns a BalancingKeyFunc which uses the value of a cookie
+func CookieKey(name string) BalancingKeyFunc {
+	return func(ctx *fasthttp.RequestCtx) []byte {
+		return ctx.Request.Header.Cookie(name)
+	}
+}
+
+// PathSegmentKey returns a BalancingKeyFunc which uses the segment number
+// "idx" (starting with 0) of the request path. For example PathSegmentKey(1)
+// returns "123" for "/users/123/profile".
+func PathSegmentKey(idx int) BalancingKeyFunc {
+	return func(ctx *fasthttp.RequestCtx) []byte {
+		path := bytes.TrimPrefix(ctx.Path(), []byte("/"))
+		for i := 0; i < idx; i++ {
+			slashIdx := bytes.IndexByte(path, '/')
+			if slashIdx < 0 {
+				return nil
+			}
+			path = path[slashIdx+1:]
+		}
+		if slashIdx := bytes.IndexByte(path, '/'); slashIdx >= 0 {
+			path = path[:slashIdx]
+		}
+		return path
+	}
+}
+
+func hashKey(key []byte) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write(key)
+	return h.Sum64()
+}
+
+// rendezvousScore mixes hashes of a key and a backend (the finalizer of
+// splitmix64), the backend with the highest score wins.
+func rendezvousScore(keyHash, backendHash uint64) uint64 {
+	z := keyHash ^ backendHash
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func pickBackendByHash(backends []*SocketBackend, keyHash uint64) (best *SocketBackend) {
+	var bestScore uint64
+	for _, backend := range backends {
+		score := rendezvousScore(keyHash, backend.hash)
+		if best == nil || score > bestScore {
+			best, bestScore = backend, score
+		}
+	}
+	return
+}
+
+// SocketBackend is a SocketClient of one of the addresses of
+// BalancedSocketClient.
+type SocketBackend struct {
+	*SocketClient
+
+	spec                string // the full address ("datamodel:serializer:family:address")
+	hash                uint64
+	inFlight            int64
+	consecutiveFailures int64
+	ejectedUntil        int64 // unix nano
+}
+
+// InFlight returns the amount of requests in progress
+func (backend *SocketBackend) InFlight() int64 {
+	return atomic.LoadInt64(&backend.inFlight)
+}
+
+// IsEjected returns true if the backend is temporary excluded from balancing
+// due to consecutive failures.
+func (backend *SocketBackend) IsEjected() bool {
+	return time.Now().UnixNano() < atomic.LoadInt64(&backend.ejectedUntil)
+}
+
+func (backend *SocketBackend) IsHealthy() bool {
+	return !backend.IsEjected() && backend.SocketClient.IsHealthy()
+}
+
+// BalancedSocketClient balances requests across SocketClient-s of multiple
+// addresses (for example: one socket per worker process).
+type BalancedSocketClient struct {
+	spinlock.Locker
+
+	Logger        Logger
+	Strategy      BalancingStrategy
+	KeyFunc       BalancingKeyFunc
+	RetryPolicy   RetryPolicy
+	HedgeDelay    time.Duration
+	EjectFailures int
+	EjectTimeout  time.Duration
+
+	config     Config
+	asyncQueue asyncQueue
+	discovery  *SocketDiscovery
+	backends   []*SocketBackend
+	pointer    uint64
+	connCount  int
+}
+
+// NewBalancedSocketClient creates a client for addresses cfg.Addresses. Each
+// address has the same syntax as Config.Address. Backends for
+// cfg.DiscoveryAddress are added on Start.
+func NewBalancedSocketClient(cfg Config) (*BalancedSocketClient, error) {
+	if len(cfg.Addresses) == 0 && cfg.DiscoveryAddress == "" {
+		return nil, ErrNoAddresses
+	}
+	if cfg.BalancingStrategy == BalancingConsistentHash && cfg.BalancingKeyFunc == nil {
+		return nil, ErrNoBalancingKey
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = dummyLogger
+	}
+
+	sock := &BalancedSocketClient{
+		Logger:        cfg.Logger,
+		Strategy:      cfg.BalancingStrategy,
+		KeyFunc:       cfg.BalancingKeyFunc,
+		HedgeDelay:    cfg.HedgeDelay,
+		EjectFailures: cfg.EjectFailures,
+		EjectTimeout:  cfg.EjectTimeout,
+		config:        cfg,
+	}
+	if cfg.RetryPolicy != nil {
+		sock.RetryPolicy = *cfg.RetryPolicy
+	}
+	if sock.EjectFailures <= 0 {
+		sock.EjectFailures = defaultEjectFailures
+	}
+	if sock.EjectTimeout <= 0 {
+		sock.EjectTimeout = defaultEjectTimeout
+	}
+
+	for _, address := range cfg.Addresses {
+		backend, err := sock.newBackend(address)
+		if err != nil {
+			return nil, err
+		}
+		sock.backends = append(sock.backends, backend)
+	}
+
+	if cfg.DiscoveryAddress != "" {
+		discovery, err := NewSocketDiscovery(sock, cfg.DiscoveryAddress, cfg.DiscoveryInterval)
+		if err != nil {
+			return nil, err
+		}
+		sock.discovery = discovery
+	}
+
+	return sock, nil
+}
+
+func (sock *BalancedSocketClient) newBackend(address string) (*SocketBackend, error) {
+	cfg := sock.config
+	cfg.Address = address
+	cfg.RetryPolicy = &RetryPolicy{} // requests are repeated by BalancedSocketClient (maybe on other backends)
+	cfg.HedgeDelay = 0               // hedged requests are sent to other backends as well
+	client, err := NewSocketClient(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, address)
+	}
+	if client.CircuitBreaker != nil { // is a copy per client
+		client.CircuitBreaker.Name = address
+	}
+	return &SocketBackend{
+		SocketClient: client,
+		spec:         address,
+		hash:         hashKey([]byte(address)),
+	}, nil
+}
+
+// AddBackend adds a backend with address "address" (the syntax is the same as
+// of Config.Address). If the client is already started then connections to the
+// backend are opened.
+func (sock *BalancedSocketClient) AddBackend(address string) error {
+	backend, err := sock.newBackend(address)
+	if err != nil {
+		return err
+	}
+
+	var connCount int
+	sock.LockDo(func() {
+		for _, oldBackend := range sock.backends {
+			if oldBackend.spec == backend.spec {
+				err = errors.Wrap(ErrBackendExists, address)
+				return
+			}
+		}
+		connCount = sock.connCount
+		// copy-on-write: Backends() results remain valid
+		backends := make([]*SocketBackend, 0, len(sock.backends)+1)
+		backends = append(backends, sock.backends...)
+		sock.backends = append(backends, backend)
+	})
+	if err != nil {
+		return err
+	}
+
+	if connCount > 0 {
+		sock.resizeAsyncQueue()
+		return backend.startConns(connCount)
+	}
+	return nil
+}
+
+// RemoveBackend removes the backend with address "address" and closes its
+// connections.
+func (sock *BalancedSocketClient) RemoveBackend(address string) error {
+	var removed *SocketBackend
+	sock.LockDo(func() {
+		backends := make([]*SocketBackend, 0, len(sock.backends))
+		for _, backend := range sock.backends {
+			if removed == nil && backend.spec == address {
+				removed = backend
+				continue
+			}
+			backends = append(backends, backend)
+		}
+		sock.backends = backends
+	})
+	if removed == nil {
+		return errors.Wrap(ErrUnknownBackend, address)
+	}
+
+	removed.Close()
+	sock.resizeAsyncQueue()
+	return nil
+}
+
+// Start opens "connCount" connections to each backend and starts the
+// discovery (if Config.DiscoveryAddress is set).
+func (sock *BalancedSocketClient) Start(connCount int) error {
+	sock.LockDo(func() {
+		sock.connCount = connCount
+	})
+	for _, backend := range sock.Backends() {
+		if err := backend.startConns(connCount); err != nil {
+			return err
+		}
+	}
+
+	sock.asyncQueue.start(sock.config.AsyncQueueSize, sock.asyncWorkerCount(), sock.SendAndReceive)
+	if sock.discovery != nil {
+		if err := sock.discovery.Start(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// asyncWorkerCount returns the amount of workers of SendAsync: one per
+// connection of each backend (but not less than connections of one backend)
+func (sock *BalancedSocketClient) asyncWorkerCount() (r int) {
+	sock.LockDo(func() {
+		r = sock.connCount * len(sock.backends)
+		if r < sock.connCount {
+			r = sock.connCount
+		}
+	})
+	return
+}
+
+// resizeAsyncQueue updates the amount of workers of SendAsync after adding
+// or removing a backend
+func (sock *BalancedSocketClient) resizeAsyncQueue() {
+	sock.asyncQueue.resize(sock.asyncWorkerCount())
+}
+
+// Close stops the discovery and closes connections of all backends.
+func (sock *BalancedSocketClient) Close() {
+	sock.asyncQueue.stop()
+	if sock.discovery != nil {
+		sock.discovery.Stop()
+	}
+	for _, backend := range sock.Backends() {
+		backend.Close()
+	}
+}
+
+// Backends returns the current list of backends
+func (sock *BalancedSocketClient) Backends() (r []*SocketBackend) {
+	sock.LockDo(func() {
+		r = sock.backends
+	})
+	return
+}
+
+// pickBackend returns a healthy backend according to the Strategy. Backends
+// from "exclude" are skipped.
+func (sock *BalancedSocketClient) pickBackend(ctx *fasthttp.RequestCtx, exclude *SocketBackend) *SocketBackend {
+	backends := sock.Backends()
+	healthy := make([]*SocketBackend, 0, len(backends))
+	for _, backend := range backends {
+		if backend != exclude && backend.IsHealthy() {
+			healthy = append(healthy, backend)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil
+	}
+
+	switch sock.Strategy {
+	case BalancingConsistentHash:
+		if sock.KeyFunc == nil {
+			break
+		}
+		if key := sock.KeyFunc(ctx); len(key) > 0 {
+			return pickBackendByHash(healthy, hashKey(key))
+		}
+	case BalancingLeastInFlight:
+		offset := int(atomic.AddUint64(&sock.pointer, 1) % uint64(len(healthy))) // to not prefer the first backend on ties
+		best := healthy[offset]
+		for i := 1; i < len(healthy); i++ {
+			backend := healthy[(offset+i)%len(healthy)]
+			if backend.InFlight() < best.InFlight() {
+				best = backend
+			}
+		}
+		return best
+	case BalancingRandomTwoChoices:
+		first := healthy[rand.Intn(len(healthy))]
+		second := healthy[rand.Intn(len(healthy))]
+		if second.InFlight() < first.InFlight() {
+			return second
+		}
+		return first
+	}
+	return healthy[atomic.AddUint64(&sock.pointer, 1)%uint64(len(healthy))]
+}
+
+func (sock *BalancedSocketClient) sendAndReceiveTo(backend *SocketBackend, ctx *fasthttp.RequestCtx) (isSent bool, err error) {
+	atomic.AddInt64(&backend.inFlight, 1)
+	isSent, err = backend.sendAndReceive(ctx)
+	atomic.AddInt64(&backend.inFlight, -1)
+
+	sock.reportBackendResult(backend, err)
+	return
+}
+
+// reportBackendResult ejects the backend after EjectFailures consecutive
+// failures.
+func (sock *BalancedSocketClient) reportBackendResult(backend *SocketBackend, err error) {
+	switch err {
+	case nil:
+		atomic.StoreInt64(&backend.consecutiveFailures, 0)
+	case ErrBusy, errHedgeCanceled:
+	default:
+		if atomic.AddInt64(&backend.consecutiveFailures, 1) >= int64(sock.EjectFailures) {
+			atomic.StoreInt64(&backend.consecutiveFailures, 0)
+			atomic.StoreInt64(&backend.ejectedUntil, time.Now().Add(sock.EjectTimeout).UnixNano())
+			sock.Logger.Print(`[fasthttp-socket-balancer] ejected backend `, backend.Address, `: `, err)
+		}
+	}
+}
+
+func (sock *BalancedSocketClient) sendAndReceive(ctx *fasthttp.RequestCtx) (isSent bool, err error) {
+	backend := sock.pickBackend(ctx, nil)
+	if backend == nil {
+		return false, ErrNoHealthyBackends
+	}
+	if sock.HedgeDelay <= 0 {
+		return sock.sendAndReceiveTo(backend, ctx)
+	}
+
+	// a hedged request is sent to another backend
+	return hedgedSend(ctx, sock.HedgeDelay,
+		func(ctx *fasthttp.RequestCtx) (bool, error) {
+			return sock.sendAndReceiveTo(backend, ctx)
+		},
+		func(ctx *fasthttp.RequestCtx) (bool, error) {
+			otherBackend := sock.pickBackend(ctx, backend)
+			if otherBackend == nil {
+				return false, ErrNoHealthyBackends
+			}
+			return sock.sendAndReceiveTo(otherBackend, ctx)
+		},
+	)
+}
+
+// SendAndReceive sends the request of "ctx" to one of the backends and
+// receives the response into "ctx". Failed requests are repeated according
+// to the RetryPolicy (each attempt may use another backend). See also
+// Config.HedgeDelay.
+func (sock *BalancedSocketClient) SendAndReceive(ctx *fasthttp.RequestCtx) error {
+	return sock.RetryPolicy.do(ctx, sock.sendAndReceive)
+}
+
+// SendAsync is the same as SocketClient.SendAsync
+func (sock *BalancedSocketClient) SendAsync(ctx *fasthttp.RequestCtx, callback AsyncCallback) error {
+	return sock.asyncQueue.push(ctx, callback)
+}
+
+// SendFuture is the same as SocketClient.SendFuture
+func (sock *BalancedSocketClient) SendFuture(ctx *fasthttp.RequestCtx) *Future {
+	return sock.asyncQueue.pushFuture(ctx)
+}
@@ -0,0 +1,186 @@
+package fasthttpsocket
+
+import (
+	"io"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trafficstars/fasthttp"
+)
+
+func TestPickBackendByHashRemapping(t *testing.T) {
+	var backends []*SocketBackend
+	for i := 0; i < 5; i++ {
+		address := "raw:native:unixpacket:/run/app/worker-" + strconv.Itoa(i) + ".sock"
+		backends = append(backends, &SocketBackend{spec: address, hash: hashKey([]byte(address))})
+	}
+
+	const keyCount = 10000
+	picked := map[uint64]*SocketBackend{}
+	counts := map[*SocketBackend]int{}
+	for i := 0; i < keyCount; i++ {
+		keyHash := hashKey([]byte("user" + strconv.Itoa(i)))
+		backend := pickBackendByHash(backends, keyHash)
+		picked[keyHash] = backend
+		counts[backend]++
+	}
+	for _, backend := range backends {
+		assert.True(t, counts[backend] > keyCount/len(backends)/2, "uneven distribution: %v", counts[backend])
+	}
+
+	removed := backends[2]
+	backends = append(backends[:2:2], backends[3:]...)
+	for keyHash, oldBackend := range picked {
+		backend := pickBackendByHash(backends, keyHash)
+		if oldBackend != removed {
+			assert.True(t, backend == oldBackend, "a key of a remaining backend was remapped")
+		}
+	}
+}
+
+// newTestBackend returns a backend with a healthy (fake) connection
+func newTestBackend(address string) *SocketBackend {
+	return &SocketBackend{
+		SocketClient: &SocketClient{
+			Address:     address,
+			clientConns: []*SocketClientConn{{}},
+		},
+		spec: address,
+		hash: hashKey([]byte(address)),
+	}
+}
+
+func newTestBalancedClient(strategy BalancingStrategy, backendCount int) *BalancedSocketClient {
+	sock := &BalancedSocketClient{
+		Logger:        dummyLogger,
+		Strategy:      strategy,
+		EjectFailures: 2,
+		EjectTimeout:  time.Hour,
+	}
+	for i := 0; i < backendCount; i++ {
+		sock.backends = append(sock.backends, newTestBackend("backend-"+strconv.Itoa(i)))
+	}
+	return sock
+}
+
+func TestPickBackendRoundRobin(t *testing.T) {
+	sock := newTestBalancedClient(BalancingRoundRobin, 3)
+	counts := map[*SocketBackend]int{}
+	for i := 0; i < 30; i++ {
+		counts[sock.pickBackend(&fasthttp.RequestCtx{}, nil)]++
+	}
+	for _, backend := range sock.backends {
+		assert.Equal(t, 10, counts[backend])
+	}
+
+	for i := 0; i < 10; i++ {
+		assert.True(t, sock.pickBackend(&fasthttp.RequestCtx{}, sock.backends[0]) != sock.backends[0])
+	}
+}
+
+func TestPickBackendLeastInFlight(t *testing.T) {
+	sock := newTestBalancedClient(BalancingLeastInFlight, 3)
+	sock.backends[0].inFlight = 5
+	sock.backends[1].inFlight = 1
+	sock.backends[2].inFlight = 3
+	for i := 0; i < 10; i++ {
+		assert.True(t, sock.pickBackend(&fasthttp.RequestCtx{}, nil) == sock.backends[1])
+	}
+
+	// ties are spread
+	sock.backends[2].inFlight = 1
+	counts := map[*SocketBackend]int{}
+	for i := 0; i < 10; i++ {
+		counts[sock.pickBackend(&fasthttp.RequestCtx{}, nil)]++
+	}
+	assert.Equal(t, 0, counts[sock.backends[0]])
+	assert.True(t, counts[sock.backends[1]] > 0 && counts[sock.backends[2]] > 0)
+}
+
+func TestPickBackendRandomTwoChoices(t *testing.T) {
+	sock := newTestBalancedClient(BalancingRandomTwoChoices, 2)
+	sock.backends[0].inFlight = 10
+	counts := map[*SocketBackend]int{}
+	for i := 0; i < 1000; i++ {
+		counts[sock.pickBackend(&fasthttp.RequestCtx{}, nil)]++
+	}
+	// the busy backend is picked only if both choices are the busy one
+	assert.True(t, counts[sock.backends[0]] < 400, "%v", counts[sock.backends[0]])
+	assert.True(t, counts[sock.backends[1]] > 600, "%v", counts[sock.backends[1]])
+}
+
+func TestBackendEjection(t *testing.T) {
+	sock := newTestBalancedClient(BalancingRoundRobin, 2)
+	backend := sock.backends[0]
+
+	sock.reportBackendResult(backend, io.EOF)
+	sock.reportBackendResult(backend, nil) // a success resets the counter
+	sock.reportBackendResult(backend, io.EOF)
+	sock.reportBackendResult(backend, ErrBusy) // the backend is OK, the pool is exhausted
+	assert.False(t, backend.IsEjected())
+
+	sock.reportBackendResult(backend, io.EOF)
+	assert.True(t, backend.IsEjected())
+	for i := 0; i < 10; i++ {
+		assert.True(t, sock.pickBackend(&fasthttp.RequestCtx{}, nil) == sock.backends[1])
+	}
+	assert.Nil(t, sock.pickBackend(&fasthttp.RequestCtx{}, sock.backends[1]))
+
+	// the backend is re-admitted after EjectTimeout
+	atomic.StoreInt64(&backend.ejectedUntil, time.Now().Add(-time.Nanosecond).UnixNano())
+	assert.False(t, backend.IsEjected())
+	counts := map[*SocketBackend]int{}
+	for i := 0; i < 10; i++ {
+		counts[sock.pickBackend(&fasthttp.RequestCtx{}, nil)]++
+	}
+	assert.Equal(t, 5, counts[backend])
+}
+
+func TestBalancedSocketClientAsyncWorkers(t *testing.T) {
+	dir := t.TempDir()
+	sock, err := NewBalancedSocketClient(Config{
+		Addresses: []string{
+			"fasthttp:native:unixpacket:" + dir + "/worker-0.sock",
+			"fasthttp:native:unixpacket:" + dir + "/worker-1.sock",
+		},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, sock.Start(2))
+	defer sock.Close()
+
+	workerCount := func() (r int) {
+		sock.asyncQueue.LockDo(func() {
+			r = sock.asyncQueue.workers
+		})
+		return
+	}
+	assert.Equal(t, 4, workerCount())
+	for _, backend := range sock.Backends() {
+		assert.Nil(t, backend.asyncQueue.stopChan, "backends don't have own workers")
+	}
+
+	assert.NoError(t, sock.AddBackend("fasthttp:native:unixpacket:"+dir+"/worker-2.sock"))
+	assert.Equal(t, 6, workerCount())
+	assert.NoError(t, sock.RemoveBackend("fasthttp:native:unixpacket:"+dir+"/worker-0.sock"))
+	assert.Equal(t, 4, workerCount())
+}
+
+func TestNewBalancedSocketClientConsistentHashKey(t *testing.T) {
+	cfg := Config{
+		Addresses:         []string{"fasthttp:native:unixpacket:/nonexistent.sock"},
+		BalancingStrategy: BalancingConsistentHash,
+	}
+	_, err := NewBalancedSocketClient(cfg)
+	assert.Equal(t, ErrNoBalancingKey, err)
+
+	cfg.BalancingKeyFunc = HeaderKey("X-User-Id")
+	sock, err := NewBalancedSocketClient(cfg)
+	if assert.NoError(t, err) {
+		sock.Close()
+	}
+}
@@ -1,13 +1,28 @@
 package fasthttpsocket
 
 import (
+	"net"
+	"time"
+
 	"github.com/pkg/errors"
 	"github.com/trafficstars/fasthttp"
 	"github.com/trafficstars/spinlock"
 )
 
 var (
-	ErrBusy = errors.New(`[fasthttp-socket-client] all connections are busy`)
+	ErrBusy                 = errors.New(`[fasthttp-socket-client] all connections are busy`)
+	ErrNoHealthyConnections = errors.New(`[fasthttp-socket-client] no healthy connections (all connections are reconnecting)`)
+	ErrUnexpectedResponseID = errors.New(`[fasthttp-socket-client] received a response with an unexpected request ID`)
+)
+
+// DialFunc opens a connection to the address (for example, with custom
+// socket options or to an in-memory listener in tests). "network" and
+// "address" are the family and the address of Config.Address.
+type DialFunc func(network, address string) (net.Conn, error)
+
+const (
+	defaultReconnectMinDelay = 10 * time.Millisecond
+	defaultReconnectMaxDelay = 5 * time.Second
 )
 
 type SocketClient struct {
@@ -19,7 +34,21 @@ type SocketClient struct {
 	DataModel      dataModel
 	Family         Family
 	Address        string
+	Dial           DialFunc
+	FastCGIParams  map[string]string
+
+	UserValueKeys         []string
+	ResponseUserValueKeys []string
 
+	ReconnectMinDelay time.Duration
+	ReconnectMaxDelay time.Duration
+
+	RetryPolicy    RetryPolicy
+	CircuitBreaker *CircuitBreaker
+	HedgeDelay     time.Duration
+	AsyncQueueSize int
+
+	asyncQueue          asyncQueue
 	clientConnPointer   int
 	clientConns         []*SocketClientConn
 	requiredClientConns int
@@ -28,16 +57,56 @@ type SocketClient struct {
 func NewSocketClient(cfg Config) (*SocketClient, error) {
 	var err error
 	sock := &SocketClient{
-		Logger: cfg.Logger,
+		Dial:          cfg.Dial,
+		FastCGIParams: cfg.FastCGIParams,
+
+		UserValueKeys:         cfg.UserValueKeys,
+		ResponseUserValueKeys: cfg.ResponseUserValueKeys,
+
+		ReconnectMinDelay: cfg.ReconnectMinDelay,
+		ReconnectMaxDelay: cfg.ReconnectMaxDelay,
+
+		HedgeDelay:     cfg.HedgeDelay,
+		AsyncQueueSize: cfg.AsyncQueueSize,
+	}
+	if cfg.RetryPolicy != nil {
+		sock.RetryPolicy = *cfg.RetryPolicy
 	}
 	sock.NewEncoderFunc, sock.NewDecoderFunc, sock.DataModel, sock.Family, sock.Address, err = parseConfig(&cfg)
 	if err != nil {
 		return nil, err
 	}
+	sock.Logger = cfg.Logger       // parseConfig sets the default logger
+	if cfg.CircuitBreaker != nil { // the state of the circuit is per client
+		sock.CircuitBreaker = cfg.CircuitBreaker.Clone()
+		sock.CircuitBreaker.Probe = cfg.CircuitBreaker.Probe
+		if sock.CircuitBreaker.Probe == nil {
+			sock.CircuitBreaker.Probe = sock.hasHealthyConn
+		}
+	}
+	if sock.ReconnectMinDelay <= 0 {
+		sock.ReconnectMinDelay = defaultReconnectMinDelay
+	}
+	if sock.ReconnectMaxDelay < sock.ReconnectMinDelay {
+		sock.ReconnectMaxDelay = defaultReconnectMaxDelay
+		if sock.ReconnectMaxDelay < sock.ReconnectMinDelay {
+			sock.ReconnectMaxDelay = sock.ReconnectMinDelay
+		}
+	}
 	return sock, err
 }
 
 func (sock *SocketClient) Start(connCount int) error {
+	if err := sock.startConns(connCount); err != nil {
+		return err
+	}
+	sock.asyncQueue.start(sock.AsyncQueueSize, connCount, sock.SendAndReceive)
+	return nil
+}
+
+// startConns opens connections (without workers of SendAsync, so it's used
+// by BalancedSocketClient for backends)
+func (sock *SocketClient) startConns(connCount int) error {
 	sock.requiredClientConns = connCount
 
 	for sock.getClientConnCount() < sock.requiredClientConns {
@@ -46,10 +115,25 @@ func (sock *SocketClient) Start(connCount int) error {
 			return err
 		}
 	}
-
 	return nil
 }
 
+// Close closes all connections of the client
+func (sock *SocketClient) Close() {
+	sock.asyncQueue.stop()
+	if sock.CircuitBreaker != nil {
+		sock.CircuitBreaker.Close()
+	}
+
+	var conns []*SocketClientConn
+	sock.LockDo(func() {
+		conns = append(conns, sock.clientConns...)
+	})
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
 func (sock *SocketClient) getClientConnCount() (r int) {
 	sock.LockDo(func() {
 		r = len(sock.clientConns)
@@ -68,40 +152,137 @@ func (sock *SocketClient) addClientConn() error {
 	return nil
 }
 
-func (sock *SocketClient) acquireClientConnection() (r *SocketClientConn) {
+// IsHealthy returns true if the client has a healthy connection and its
+// circuit breaker (if any) is not open.
+func (sock *SocketClient) IsHealthy() bool {
+	if sock.CircuitBreaker != nil && sock.CircuitBreaker.IsOpen() {
+		return false
+	}
+	return sock.hasHealthyConn()
+}
+
+// hasHealthyConn returns true if the client has a connection which is not
+// waiting for a reconnect
+func (sock *SocketClient) hasHealthyConn() (r bool) {
 	sock.LockDo(func() {
-		if int(sock.clientConnPointer) >= len(sock.clientConns) {
-			sock.clientConnPointer = 0
+		for _, conn := range sock.clientConns {
+			if conn.IsHealthy() {
+				r = true
+				return
+			}
 		}
-		count := 0
-		oldIdx := sock.clientConnPointer
-		for !sock.clientConns[sock.clientConnPointer].TryLock() {
-			sock.clientConnPointer++
-			count++
-			if int(sock.clientConnPointer) >= len(sock.clientConns) {
-				sock.clientConnPointer = 0
+	})
+	return
+}
+
+// acquireClientConnection locks and returns the next healthy connection.
+// Broken connections are skipped until they are reconnected in the background.
+func (sock *SocketClient) acquireClientConnection() (r *SocketClientConn, err error) {
+	sock.LockDo(func() {
+		connCount := len(sock.clientConns)
+		hasHealthy := false
+		for i := 0; i < connCount; i++ {
+			idx := (sock.clientConnPointer + i) % connCount
+			conn := sock.clientConns[idx]
+			if !conn.IsHealthy() {
+				continue
 			}
-			if sock.clientConnPointer == oldIdx || count > 100 { // all connections are busy, cannot lock/acquire any connection
-				return
+			hasHealthy = true
+			if !conn.TryLock() {
+				continue
+			}
+			if !conn.IsHealthy() { // it was broken while we were locking it
+				conn.Unlock()
+				continue
 			}
+			sock.clientConnPointer = (idx + 1) % connCount
+			r = conn
+			return
+		}
+		if hasHealthy {
+			err = ErrBusy
+		} else {
+			err = ErrNoHealthyConnections
 		}
-		r = sock.clientConns[sock.clientConnPointer]
-		sock.clientConnPointer++
 	})
 	return
 }
 
+// SendAndReceive sends the request of "ctx" and receives the response into
+// "ctx", failed requests are repeated according to the RetryPolicy.
 func (sock *SocketClient) SendAndReceive(ctx *fasthttp.RequestCtx) error {
-	conn := sock.acquireClientConnection()
-	if conn == nil {
-		return ErrBusy
+	return sock.SendAndReceiveWithRetryPolicy(ctx, &sock.RetryPolicy)
+}
+
+// SendAndReceiveWithRetryPolicy is the same as SendAndReceive, but uses
+// "policy" instead of the RetryPolicy of the client.
+func (sock *SocketClient) SendAndReceiveWithRetryPolicy(ctx *fasthttp.RequestCtx, policy *RetryPolicy) error {
+	return policy.do(ctx, sock.sendAndReceiveHedged)
+}
+
+// SendAsync queues the request of "ctx" and returns immediately. "callback" is
+// called when the response is received into "ctx" (or the request failed).
+// If the queue is full then ErrAsyncQueueFull is returned and "callback" is
+// not called.
+func (sock *SocketClient) SendAsync(ctx *fasthttp.RequestCtx, callback AsyncCallback) error {
+	return sock.asyncQueue.push(ctx, callback)
+}
+
+// SendFuture is the same as SendAsync, but returns a Future
+func (sock *SocketClient) SendFuture(ctx *fasthttp.RequestCtx) *Future {
+	return sock.asyncQueue.pushFuture(ctx)
+}
+
+func (sock *SocketClient) sendAndReceiveHedged(ctx *fasthttp.RequestCtx) (isSent bool, err error) {
+	return hedgedSend(ctx, sock.HedgeDelay, sock.sendAndReceive, sock.sendAndReceive)
+}
+
+func (sock *SocketClient) sendAndReceive(ctx *fasthttp.RequestCtx) (isSent bool, err error) {
+	attempt := getHedgeAttempt(ctx)
+	if attempt.isCanceled() {
+		return false, errHedgeCanceled
 	}
 
-	err := conn.SendAndReceive(ctx)
-	conn.release()
+	cb := sock.CircuitBreaker
+	var cbGeneration uint64
+	if cb != nil {
+		if cbGeneration, err = cb.Allow(); err != nil {
+			return false, err
+		}
+	}
+
+	conn, err := sock.acquireClientConnection()
 	if err != nil {
-		return err
+		if cb != nil {
+			if err == ErrBusy { // the backend is OK, just the pool is exhausted
+				cb.Cancel(cbGeneration)
+			} else {
+				cb.Failure(cbGeneration)
+			}
+		}
+		return false, err
 	}
 
-	return nil
+	stopAbort := attempt.abortOnCancel(conn.Conn)
+	isSent, err = conn.sendAndReceiveSafe(ctx)
+	if stopAbort() {
+		// the state of the connection is unknown after the deadline
+		conn.setBroken()
+		if err != nil {
+			err = errHedgeCanceled
+		}
+	}
+	conn.release()
+
+	if cb != nil {
+		switch err {
+		case nil:
+			cb.Success(cbGeneration)
+		case errHedgeCanceled:
+			cb.Cancel(cbGeneration)
+		default:
+			cb.Failure(cbGeneration)
+		}
+	}
+	return
 }
@@ -1,9 +1,15 @@
 package fasthttpsocket
 
 import (
+	"bufio"
 	"fmt"
 	"io"
+	"math/rand"
 	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
 
 	"github.com/trafficstars/fasthttp"
 	"github.com/trafficstars/spinlock"
@@ -22,18 +28,35 @@ type SocketClientConn struct {
 
 	Request  TransmittableRequest
 	Response TransmittableResponse
+
+	writeBuffer      *bufio.Writer // is used only while sending a batch
+	batchWriteBuffer *bufio.Writer
+	writtenBytes     int // limits windows of pipelined requests
+	lastRequestID    uint64
+
+	isBroken  int32
+	isClosed  int32
+	closeChan chan struct{} // stops reconnectLoop
 }
 
 func newSocketClientConn(
 	sock *SocketClient,
 ) (*SocketClientConn, error) {
-	c := &SocketClientConn{SocketClient: sock}
-	_ = c.Reconnect()
-
+	c := &SocketClientConn{
+		SocketClient: sock,
+		closeChan:    make(chan struct{}),
+	}
 	c.Encoder = sock.NewEncoderFunc(c)
 	c.Decoder = sock.NewDecoderFunc(c)
+	if err := c.Reconnect(); err != nil {
+		c.setBroken()
+	}
 
 	c.ModelCodec = sock.DataModel.GetClientCodec()
+	if codec, ok := c.ModelCodec.(*ClientCodecFastCGI); ok {
+		codec.Params = sock.FastCGIParams
+		codec.Logger = sock.Logger
+	}
 
 	c.Request = c.ModelCodec.GetRequest()
 	c.Response = c.ModelCodec.GetResponse()
@@ -45,14 +68,77 @@ func (c *SocketClientConn) Reconnect() error {
 	if c.Conn != nil {
 		c.Conn.Close()
 	}
-	c.Conn, err = net.Dial(c.Family.String(), c.Address)
+	switch {
+	case c.Dial != nil:
+		c.Conn, err = c.Dial(c.Family.String(), c.Address)
+	case c.Family == FamilyUnixGram:
+		c.Conn, err = dialUnixGram(c.Address)
+	default:
+		c.Conn, err = net.Dial(c.Family.String(), c.Address)
+	}
 	if err == nil {
 		c.Messanger = NewMessanger(c.Conn)
+		// encoders and decoders are stateful (gob type info, buffered data)
+		c.Encoder = c.NewEncoderFunc(c)
+		c.Decoder = c.NewDecoderFunc(c)
+		if listener, ok := c.ModelCodec.(reconnectListener); ok {
+			listener.onReconnect()
+		}
 	}
 	return err
 }
 
+// IsHealthy returns false if the connection is closed or is waiting for a
+// reconnect.
+func (c *SocketClientConn) IsHealthy() bool {
+	return atomic.LoadInt32(&c.isBroken) == 0 && atomic.LoadInt32(&c.isClosed) == 0
+}
+
+// setBroken marks the connection as broken and starts reconnecting it in the
+// background (if not started, yet).
+func (c *SocketClientConn) setBroken() {
+	if atomic.CompareAndSwapInt32(&c.isBroken, 0, 1) {
+		go c.reconnectLoop()
+	}
+}
+
+func (c *SocketClientConn) reconnectLoop() {
+	delay := c.ReconnectMinDelay
+	for atomic.LoadInt32(&c.isClosed) == 0 {
+		// "equal jitter": a half of the delay is random
+		timer := time.NewTimer(delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1)))
+		select {
+		case <-c.closeChan:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		var err error
+		c.LockDo(func() {
+			if atomic.LoadInt32(&c.isClosed) != 0 {
+				return
+			}
+			err = c.Reconnect()
+		})
+		if err == nil {
+			atomic.StoreInt32(&c.isBroken, 0)
+			return
+		}
+		c.Logger.Print(`[fasthttp-socket-client] unable to reconnect to `, c.Address, `: `, err)
+
+		delay *= 2
+		if delay > c.ReconnectMaxDelay {
+			delay = c.ReconnectMaxDelay
+		}
+	}
+}
+
 func (c *SocketClientConn) Close() {
+	if atomic.CompareAndSwapInt32(&c.isClosed, 0, 1) {
+		close(c.closeChan)
+	}
+
 	sock := c.SocketClient
 
 	sock.LockDo(func() {
@@ -73,8 +159,12 @@ func (c *SocketClientConn) Close() {
 		sock.clientConns = newClientConns
 	})
 
-	_ = c.Conn.Close()
-	c.Messanger = nil
+	c.LockDo(func() {
+		if c.Conn != nil {
+			_ = c.Conn.Close()
+		}
+		c.Messanger = nil
+	})
 }
 
 func (c *SocketClientConn) Read(b []byte) (int, error) {
@@ -84,60 +174,143 @@ func (c *SocketClientConn) Read(b []byte) (int, error) {
 	return c.Messanger.Read(b)
 }
 
-func (c *SocketClientConn) Write(b []byte) (int, error) {
-	if c.Messanger == nil {
+func (c *SocketClientConn) Write(b []byte) (n int, err error) {
+	switch {
+	case c.writeBuffer != nil:
+		n, err = c.writeBuffer.Write(b)
+	case c.Messanger == nil:
 		return 0, io.ErrClosedPipe
+	default:
+		n, err = c.Messanger.Write(b)
 	}
-	return c.Messanger.Write(b)
+	c.writtenBytes += n
+	return
 }
 
 func (c *SocketClientConn) release() {
 	c.Unlock()
 }
 
-func (c *SocketClientConn) sendAndReceive(ctx *fasthttp.RequestCtx) error {
+// setRequestID sets the next request ID (if the model carries request IDs)
+func (c *SocketClientConn) setRequestID(request TransmittableRequest) uint64 {
+	carrier, ok := request.(requestIDCarrier)
+	if !ok {
+		return 0
+	}
+	c.lastRequestID++
+	*carrier.requestID() = c.lastRequestID
+	return c.lastRequestID
+}
+
+// sendAndReceive returns isSent == true if the request could be received by
+// the server.
+func (c *SocketClientConn) sendAndReceive(ctx *fasthttp.RequestCtx) (isSent bool, err error) {
 	request := c.Request
 	response := c.Response
 
-	err := c.ModelCodec.Encode(request, ctx)
+	// "ctx" may be copied by hedgedSend concurrently, so it's accessed only
+	// with locked "attempt"
+	attempt := getHedgeAttempt(ctx)
+
+	var requestID uint64
+	attempt.lockDo(func() {
+		err = c.ModelCodec.Encode(request, ctx)
+		if err != nil {
+			return
+		}
+		requestID = c.setRequestID(request)
+		if carrier, ok := request.(connInfoCarrier); ok {
+			carrier.connInfo().fill(ctx)
+		}
+		if carrier, ok := request.(userValuesCarrier); ok {
+			carrier.userValues().fill(ctx, c.UserValueKeys)
+		}
+	})
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	err = c.Encoder.Encode(request)
 	if err != nil {
-		err2 := c.Reconnect()
-		if err2 != nil {
-			return err2
-		}
-		err = c.Encoder.Encode(request)
-		if err != nil {
-			return err
-		}
+		c.setBroken()
+		return false, err
 	}
 
+	response.Reset()
 	err = c.Decoder.Decode(response)
 	if err != nil {
-		err2 := c.Reconnect()
-		if err2 != nil {
-			return err2
-		}
-		return err
+		c.setBroken()
+		return true, err
+	}
+	if carrier, ok := response.(requestIDCarrier); ok && *carrier.requestID() != requestID {
+		c.setBroken() // the stream is out of sync
+		return true, ErrUnexpectedResponseID
 	}
 
-	err = c.ModelCodec.Decode(ctx, response)
+	attempt.lockDo(func() {
+		err = c.ModelCodec.Decode(ctx, response)
+		if err != nil {
+			return
+		}
+		if carrier, ok := response.(userValuesCarrier); ok {
+			carrier.userValues().apply(ctx, c.ResponseUserValueKeys, false)
+		}
+	})
 	if err != nil {
-		return err
+		return true, err
 	}
 
-	return nil
+	if closer, ok := response.(connectionCloser); ok && closer.ConnectionClose() {
+		if err := c.Reconnect(); err != nil {
+			c.setBroken()
+		}
+	}
+
+	return true, nil
 }
 
-func (c *SocketClientConn) SendAndReceive(ctx *fasthttp.RequestCtx) (ret error) {
+func (c *SocketClientConn) sendAndReceiveSafe(ctx *fasthttp.RequestCtx) (isSent bool, ret error) {
 	defer func() { // gob.Decoder panics sometimes
 		if r := recover(); r != nil {
+			c.setBroken()
+			isSent = true
 			ret = fmt.Errorf("panic: %v", r)
 		}
 	}()
 	return c.sendAndReceive(ctx)
 }
+
+func (c *SocketClientConn) SendAndReceive(ctx *fasthttp.RequestCtx) error {
+	_, err := c.sendAndReceiveSafe(ctx)
+	return err
+}
+
+var unixGramLocalAddrCounter uint64
+
+// unixGramConn removes the file of its local address on Close
+type unixGramConn struct {
+	*net.UnixConn
+}
+
+func (conn unixGramConn) Close() error {
+	localAddr := conn.LocalAddr().String()
+	err := conn.UnixConn.Close()
+	_ = os.Remove(localAddr)
+	return err
+}
+
+// dialUnixGram connects to a "unixgram" socket from a temporary local
+// address: a server can't respond to an unbound socket.
+func dialUnixGram(address string) (net.Conn, error) {
+	localAddr := &net.UnixAddr{
+		Name: filepath.Join(os.TempDir(), fmt.Sprintf(`fasthttpsocket-%d-%d.sock`,
+			os.Getpid(), atomic.AddUint64(&unixGramLocalAddrCounter, 1))),
+		Net: `unixgram`,
+	}
+	conn, err := net.DialUnix(`unixgram`, localAddr, &net.UnixAddr{Name: address, Net: `unixgram`})
+	if err != nil {
+		_ = os.Remove(localAddr.Name) // could be bound before the error
+		return nil, err
+	}
+	return unixGramConn{conn}, nil
+}
@@ -0,0 +1,103 @@
+package fasthttpsocket
+
+import (
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/trafficstars/fasthttp"
+)
+
+// waitFor polls "cond" up to a second
+func waitFor(cond func() bool) bool {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if cond() {
+			return true
+		}
+	}
+	return cond()
+}
+
+func startTestServer(t *testing.T, address string, handler fasthttp.RequestHandler) *SocketServer {
+	srv, err := NewSocketServerWithRequestHandler(handler, Config{
+		Address:               address,
+		UnixSocketPermissions: 0700,
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	if !assert.NoError(t, srv.Start()) {
+		t.FailNow()
+	}
+	return srv
+}
+
+func TestClientReconnect(t *testing.T) {
+	address := "fasthttp:native:unixpacket:" + filepath.Join(t.TempDir(), "reconnect.sock")
+	handler := func(ctx *fasthttp.RequestCtx) {
+		ctx.SetBodyString("ok")
+	}
+	srv := startTestServer(t, address, handler)
+
+	client, err := NewSocketClient(Config{
+		Address:           address,
+		ReconnectMinDelay: time.Millisecond,
+		ReconnectMaxDelay: 10 * time.Millisecond,
+		RetryPolicy:       &RetryPolicy{},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.NoError(t, client.Start(1)) {
+		return
+	}
+	defer client.Close()
+
+	ctx := &fasthttp.RequestCtx{}
+	assert.NoError(t, client.SendAndReceive(ctx))
+	assert.Equal(t, "ok", string(ctx.Response.Body()))
+	assert.True(t, client.IsHealthy())
+
+	// the server is down: the connection is broken and can't be reconnected
+	assert.NoError(t, srv.Stop())
+	assert.Error(t, client.SendAndReceive(&fasthttp.RequestCtx{}))
+	assert.False(t, client.IsHealthy())
+	assert.Equal(t, ErrNoHealthyConnections, client.SendAndReceive(&fasthttp.RequestCtx{}))
+
+	// the server is back: the connection is reconnected in the background
+	srv = startTestServer(t, address, handler)
+	defer srv.Stop()
+	assert.True(t, waitFor(client.IsHealthy))
+	ctx = &fasthttp.RequestCtx{}
+	assert.NoError(t, client.SendAndReceive(ctx))
+	assert.Equal(t, "ok", string(ctx.Response.Body()))
+}
+
+func TestClientCloseStopsReconnecting(t *testing.T) {
+	var dialCount int32
+	client, err := NewSocketClient(Config{
+		Address:           "fasthttp:native:unixpacket:/nonexistent.sock",
+		ReconnectMinDelay: time.Millisecond,
+		ReconnectMaxDelay: time.Millisecond,
+		Dial: func(network, address string) (net.Conn, error) {
+			atomic.AddInt32(&dialCount, 1)
+			return nil, syscall.ECONNREFUSED
+		},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, client.Start(1))
+	assert.False(t, client.IsHealthy())
+	assert.True(t, waitFor(func() bool { return atomic.LoadInt32(&dialCount) > 2 }))
+
+	client.Close()
+	time.Sleep(10 * time.Millisecond)
+	dialCountAfterClose := atomic.LoadInt32(&dialCount)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, dialCountAfterClose, atomic.LoadInt32(&dialCount))
+}
@@ -0,0 +1,169 @@
+package fasthttpsocket
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/trafficstars/spinlock"
+)
+
+const (
+	defaultDiscoveryInterval = time.Second
+)
+
+// SocketDiscovery watches socket files matching a glob pattern and adds or
+// removes backends of a BalancedSocketClient accordingly. A socket file which
+// was replaced (for example by a restarted process) is detected by the inode
+// and the modification time, its backend is recreated.
+type SocketDiscovery struct {
+	spinlock.Locker
+
+	Client   *BalancedSocketClient
+	Interval time.Duration
+
+	prefix   string // "datamodel:serializer:family:"
+	pattern  string
+	known    map[string]os.FileInfo // is accessed under the lock
+	stopChan chan struct{}
+	doneChan chan struct{} // is closed when the polling goroutine exits
+}
+
+// NewSocketDiscovery creates a discovery for "address" which has the same
+// syntax as Config.Address, but the path may be a glob pattern. For example:
+// "raw:native:unixpacket:/run/app/worker-*.sock".
+func NewSocketDiscovery(client *BalancedSocketClient, address string, interval time.Duration) (*SocketDiscovery, error) {
+	words := strings.SplitN(address, ":", 4)
+	if len(words) < 4 {
+		return nil, ErrNotEnoughWords
+	}
+	if _, err := filepath.Match(words[3], ""); err != nil {
+		return nil, err
+	}
+	if interval <= 0 {
+		interval = defaultDiscoveryInterval
+	}
+	return &SocketDiscovery{
+		Client:   client,
+		Interval: interval,
+		prefix:   strings.Join(words[:3], ":") + ":",
+		pattern:  words[3],
+		known:    map[string]os.FileInfo{},
+	}, nil
+}
+
+// Start synchronizes backends with existing socket files and starts watching
+// for changes.
+func (d *SocketDiscovery) Start() error {
+	d.poll()
+
+	stopChan := make(chan struct{})
+	doneChan := make(chan struct{})
+	d.LockDo(func() {
+		d.stopChan = stopChan
+		d.doneChan = doneChan
+	})
+
+	go func() {
+		defer close(doneChan)
+		ticker := time.NewTicker(d.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopChan:
+				return
+			case <-ticker.C:
+				d.poll()
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Stop stops watching for changes. It waits for an in-flight poll, so
+// backends are not added or removed after it returns.
+func (d *SocketDiscovery) Stop() {
+	var doneChan chan struct{}
+	d.LockDo(func() {
+		if d.stopChan != nil {
+			close(d.stopChan)
+			d.stopChan = nil
+		}
+		doneChan, d.doneChan = d.doneChan, nil
+	})
+	if doneChan != nil {
+		<-doneChan
+	}
+}
+
+func (d *SocketDiscovery) addBackend(path string, info os.FileInfo) {
+	if err := d.Client.AddBackend(d.prefix + path); err != nil {
+		d.Client.Logger.Errorf("[fasthttp-socket-discovery] unable to add backend %v: %v\n", path, err)
+		return
+	}
+	d.Client.Logger.Print(`[fasthttp-socket-discovery] added backend `, path)
+	d.LockDo(func() {
+		d.known[path] = info
+	})
+}
+
+func (d *SocketDiscovery) removeBackend(path string) {
+	d.LockDo(func() {
+		delete(d.known, path)
+	})
+	if err := d.Client.RemoveBackend(d.prefix + path); err != nil {
+		d.Client.Logger.Errorf("[fasthttp-socket-discovery] unable to remove backend %v: %v\n", path, err)
+		return
+	}
+	d.Client.Logger.Print(`[fasthttp-socket-discovery] removed backend `, path)
+}
+
+// isSameSocketFile compares modification times in addition to inodes, because
+// a recreated socket file often gets the inode number of the removed one.
+func isSameSocketFile(a, b os.FileInfo) bool {
+	return os.SameFile(a, b) && a.ModTime().Equal(b.ModTime())
+}
+
+func (d *SocketDiscovery) poll() {
+	paths, err := filepath.Glob(d.pattern)
+	if err != nil {
+		d.Client.Logger.Errorf("[fasthttp-socket-discovery] got error: %v\n", err)
+		return
+	}
+
+	seen := make(map[string]struct{}, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || info.Mode()&os.ModeSocket == 0 {
+			continue
+		}
+		seen[path] = struct{}{}
+
+		var oldInfo os.FileInfo
+		var ok bool
+		d.LockDo(func() {
+			oldInfo, ok = d.known[path]
+		})
+		switch {
+		case !ok:
+			d.addBackend(path, info)
+		case !isSameSocketFile(oldInfo, info): // the socket was recreated
+			d.removeBackend(path)
+			d.addBackend(path, info)
+		}
+	}
+
+	var removedPaths []string
+	d.LockDo(func() {
+		for path := range d.known {
+			if _, ok := seen[path]; !ok {
+				removedPaths = append(removedPaths, path)
+			}
+		}
+	})
+	for _, path := range removedPaths {
+		d.removeBackend(path)
+	}
+}
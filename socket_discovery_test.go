@@ -0,0 +1,142 @@
+package fasthttpsocket
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSocketDiscovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fasthttpsocket-discovery")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	listen := func(name string) net.Listener {
+		listener, err := net.Listen("unix", filepath.Join(dir, name))
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		return listener
+	}
+	specs := func(sock *BalancedSocketClient) (r []string) {
+		for _, backend := range sock.Backends() {
+			r = append(r, backend.spec)
+		}
+		return
+	}
+	prefix := "raw:native:unix:"
+
+	sock, err := NewBalancedSocketClient(Config{DiscoveryAddress: prefix + filepath.Join(dir, "worker-*.sock")})
+	if !assert.NoError(t, err) {
+		return
+	}
+	discovery := sock.discovery
+
+	worker0 := listen("worker-0.sock")
+	worker1 := listen("worker-1.sock")
+	defer worker1.Close()
+	_ = ioutil.WriteFile(filepath.Join(dir, "worker-2.sock"), nil, 0600) // not a socket
+
+	discovery.poll()
+	assert.Equal(t, []string{
+		prefix + filepath.Join(dir, "worker-0.sock"),
+		prefix + filepath.Join(dir, "worker-1.sock"),
+	}, specs(sock))
+
+	oldBackend := sock.Backends()[0]
+	worker0.Close()
+	worker0 = listen("worker-0.sock") // a restarted worker
+	defer worker0.Close()
+
+	discovery.poll()
+	assert.Len(t, sock.Backends(), 2)
+	for _, backend := range sock.Backends() {
+		assert.False(t, backend == oldBackend, "the backend of the replaced socket was not recreated")
+	}
+
+	worker1.Close()
+	discovery.poll()
+	assert.Equal(t, []string{prefix + filepath.Join(dir, "worker-0.sock")}, specs(sock))
+}
+
+// testBlockingLogger blocks reports of added backends until "release" is
+// closed
+type testBlockingLogger struct {
+	blocked chan struct{}
+	release chan struct{}
+}
+
+func (l *testBlockingLogger) Errorf(fm string, args ...interface{}) {}
+
+func (l *testBlockingLogger) Print(args ...interface{}) {
+	if !strings.Contains(fmt.Sprint(args...), "added backend") {
+		return
+	}
+	select {
+	case l.blocked <- struct{}{}:
+	default:
+	}
+	<-l.release
+}
+
+func TestSocketDiscoveryStopWaitsForPoll(t *testing.T) {
+	dir := t.TempDir()
+	logger := &testBlockingLogger{
+		blocked: make(chan struct{}, 1),
+		release: make(chan struct{}),
+	}
+	sock, err := NewBalancedSocketClient(Config{
+		DiscoveryAddress:  "raw:native:unix:" + filepath.Join(dir, "worker-*.sock"),
+		DiscoveryInterval: time.Millisecond,
+		Logger:            logger,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	discovery := sock.discovery
+	assert.NoError(t, discovery.Start())
+
+	worker0, err := net.Listen("unix", filepath.Join(dir, "worker-0.sock"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer worker0.Close()
+	<-logger.blocked // a poll is in flight
+
+	stopped := make(chan struct{})
+	go func() {
+		discovery.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("Stop didn't wait for the poll")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(logger.release)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Stop didn't return after the poll")
+	}
+
+	// no backends are added after Stop
+	backendCount := len(sock.Backends())
+	worker1, err := net.Listen("unix", filepath.Join(dir, "worker-1.sock"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer worker1.Close()
+	time.Sleep(20 * time.Millisecond)
+	assert.Len(t, sock.Backends(), backendCount)
+	sock.Close()
+}
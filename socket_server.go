@@ -5,11 +5,43 @@ import (
 	"io"
 	"net"
 	"os"
+	"sync"
+	"time"
 
+	"github.com/pkg/errors"
 	"github.com/trafficstars/fasthttp"
 	"github.com/trafficstars/spinlock"
 )
 
+const (
+	defaultConnConcurrency = 8
+	minAcceptRetryDelay    = 5 * time.Millisecond
+	maxAcceptRetryDelay    = time.Second
+)
+
+var (
+	ErrServerClosed = errors.New(`[fasthttp-socket] server is closed`)
+)
+
+var (
+	serverRequestCtxPool = sync.Pool{
+		New: func() interface{} {
+			return &fasthttp.RequestCtx{}
+		},
+	}
+)
+
+func acquireServerRequestCtx() *fasthttp.RequestCtx {
+	return serverRequestCtxPool.Get().(*fasthttp.RequestCtx)
+}
+
+func releaseServerRequestCtx(ctx *fasthttp.RequestCtx) {
+	ctx.Request.Reset()
+	ctx.Response.Reset()
+	ctx.ResetUserValues()
+	serverRequestCtxPool.Put(ctx)
+}
+
 type SocketServer struct {
 	spinlock.Locker
 
@@ -22,6 +54,43 @@ type SocketServer struct {
 
 	HandleRequester       HandleRequester
 	UnixSocketPermissions os.FileMode
+	UserValueKeys         []string
+	ResponseUserValueKeys []string
+
+	// ConnConcurrency is the maximal amount of requests of a connection
+	// handled at once (if the data model carries request IDs).
+	ConnConcurrency int
+
+	// IdleTimeout limits waiting for the next request, ReadTimeout limits
+	// reading of a request (after its first byte) and WriteTimeout limits
+	// writing of a response. HandlerTimeout limits HandleRequest (see
+	// HandlerContext), the response of HandlerTimeoutResponse is sent if
+	// it's exceeded.
+	ReadTimeout            time.Duration
+	WriteTimeout           time.Duration
+	IdleTimeout            time.Duration
+	HandlerTimeout         time.Duration
+	HandlerTimeoutResponse fasthttp.RequestHandler
+
+	// MaxConns limits the amount of connections: a new connection waits up to
+	// MaxQueueWait for a slot, if there's no free slot then its first request
+	// gets the response of OverloadResponse and the connection is closed.
+	// MaxConcurrentRequests limits the amount of requests handled at once
+	// (of all connections): a request waits up to MaxQueueWait for a slot and
+	// the response of OverloadResponse is sent if there's no free slot.
+	MaxConns              int
+	MaxConcurrentRequests int
+	MaxQueueWait          time.Duration
+	OverloadResponse      fasthttp.RequestHandler
+
+	initOnce     sync.Once
+	connSlots    slots
+	requestSlots slots
+	middlewares  []Middleware
+	handler      HandleRequester // HandleRequester wrapped by middlewares
+	stopped      bool
+	listeners    map[io.Closer]struct{}
+	conns        map[net.Conn]struct{}
 }
 
 func NewSocketServer(handleRequester HandleRequester, cfg Config) (*SocketServer, error) {
@@ -30,23 +99,72 @@ func NewSocketServer(handleRequester HandleRequester, cfg Config) (*SocketServer
 		Logger:                cfg.Logger,
 		HandleRequester:       handleRequester,
 		UnixSocketPermissions: cfg.UnixSocketPermissions,
+		UserValueKeys:         cfg.UserValueKeys,
+		ResponseUserValueKeys: cfg.ResponseUserValueKeys,
+		ConnConcurrency:       cfg.ConnConcurrency,
+
+		ReadTimeout:            cfg.ReadTimeout,
+		WriteTimeout:           cfg.WriteTimeout,
+		IdleTimeout:            cfg.IdleTimeout,
+		HandlerTimeout:         cfg.HandlerTimeout,
+		HandlerTimeoutResponse: cfg.HandlerTimeoutResponse,
+
+		MaxConns:              cfg.MaxConns,
+		MaxConcurrentRequests: cfg.MaxConcurrentRequests,
+		MaxQueueWait:          cfg.MaxQueueWait,
+		OverloadResponse:      cfg.OverloadResponse,
+	}
+	if sock.HandlerTimeoutResponse == nil {
+		sock.HandlerTimeoutResponse = DefaultHandlerTimeoutResponse
+	}
+	if sock.OverloadResponse == nil {
+		sock.OverloadResponse = DefaultOverloadResponse
+	}
+	if sock.ConnConcurrency <= 0 {
+		sock.ConnConcurrency = defaultConnConcurrency
 	}
 	sock.NewEncoderFunc, sock.NewDecoderFunc, sock.DataModel, sock.Family, sock.Address, err = parseConfig(&cfg)
 	if err != nil {
 		return nil, err
 	}
+	sock.Logger = cfg.Logger // parseConfig sets the default logger
+	if sock.DataModel.GetServerCodec() == nil {
+		return nil, errors.Wrap(ErrNotImplemented, `server side of the data model`)
+	}
 	return sock, err
 }
 
+// Use adds middlewares which wrap the HandleRequester. The first added
+// middleware is the outermost one. It should be called before Start (or
+// Serve).
+func (sock *SocketServer) Use(middlewares ...Middleware) {
+	sock.middlewares = append(sock.middlewares, middlewares...)
+}
+
 func (sock *SocketServer) Start() error {
-	if sock.Family == FamilyUnixGram || sock.Family == FamilyUnixPacket {
+	if sock.isUnixFamily() {
 		os.Remove(sock.Address)
 	}
-	accepter, err := net.Listen(sock.Family.String(), sock.Address)
-	if err != nil {
-		return fmt.Errorf(`[fasthttp-socket] Cannot bind "%v:%v"\n`, sock.Family, sock.Address)
+	var serve func() error
+	switch sock.Family {
+	case FamilyUnixGram, FamilyUDP:
+		packetConn, err := net.ListenPacket(sock.Family.String(), sock.Address)
+		if err != nil {
+			return fmt.Errorf(`[fasthttp-socket] Cannot bind "%v:%v"\n`, sock.Family, sock.Address)
+		}
+		serve = func() error {
+			return sock.ServePacket(packetConn)
+		}
+	default:
+		accepter, err := net.Listen(sock.Family.String(), sock.Address)
+		if err != nil {
+			return fmt.Errorf(`[fasthttp-socket] Cannot bind "%v:%v"\n`, sock.Family, sock.Address)
+		}
+		serve = func() error {
+			return sock.Serve(accepter)
+		}
 	}
-	if sock.Family == FamilyUnixGram || sock.Family == FamilyUnixPacket {
+	if sock.isUnixFamily() && sock.UnixSocketPermissions != 0 { // 0 keeps the permissions set by umask
 		if err := os.Chmod(sock.Address, sock.UnixSocketPermissions); err != nil {
 			return fmt.Errorf(`[fasthttp-socket] Cannot change permission on socket "%v" to "%v"`, sock.Address, sock.UnixSocketPermissions)
 		}
@@ -56,18 +174,8 @@ func (sock *SocketServer) Start() error {
 	logger := sock.Logger
 
 	go func() {
-		for {
-			conn, err := accepter.Accept()
-			if err != nil {
-				logger.Errorf("[fasthttp-socket] got error: %v\n", err)
-				continue
-			}
-
-			go func() {
-				logger.Print(`[fasthttp-socket-handler] opened connection`)
-				sock.handleSocketConnection(conn)
-				logger.Print(`[fasthttp-socket-handler] closed connection`)
-			}()
+		if err := serve(); err != ErrServerClosed && !errors.Is(err, net.ErrClosed) {
+			logger.Errorf("[fasthttp-socket] stopped to accept connections: %v\n", err)
 		}
 	}()
 	logger.Print("[fasthttp-socket] Started to listen ", sock.Address, " (", sock.UnixSocketPermissions, ")")
@@ -75,8 +183,139 @@ func (sock *SocketServer) Start() error {
 	return nil
 }
 
+func (sock *SocketServer) init() {
+	sock.initOnce.Do(func() {
+		sock.handler = chainMiddlewares(sock.HandleRequester, sock.middlewares)
+		sock.connSlots = newSlots(sock.MaxConns)
+		sock.requestSlots = newSlots(sock.MaxConcurrentRequests)
+	})
+}
+
+// Serve accepts connections on "listener" and handles them. Temporary errors
+// of Accept (like "too many open files") are retried with a backoff. Returns
+// ErrServerClosed after Stop or another error of Accept (for example, if the
+// listener was closed).
+func (sock *SocketServer) Serve(listener net.Listener) error {
+	sock.init()
+	logger := sock.Logger
+
+	if !sock.trackListener(listener, true) {
+		return ErrServerClosed
+	}
+	defer sock.trackListener(listener, false)
+
+	var delay time.Duration
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if sock.isStopped() {
+				return ErrServerClosed
+			}
+			if isTemporaryError(err) {
+				delay = sock.waitAcceptRetry(err, delay)
+				continue
+			}
+			return err
+		}
+		delay = 0
+
+		if !sock.trackConn(conn, true) { // is stopped
+			_ = conn.Close()
+			return ErrServerClosed
+		}
+
+		go func() {
+			defer sock.trackConn(conn, false)
+			// a slot is waited for here to not block accepting
+			if !sock.connSlots.acquire(sock.MaxQueueWait) {
+				logger.Print(`[fasthttp-socket-handler] too many connections (`, sock.MaxConns, `), rejecting a new one`)
+				sock.rejectSocketConnection(conn)
+				return
+			}
+			defer sock.connSlots.release()
+			logger.Print(`[fasthttp-socket-handler] opened connection`)
+			sock.handleSocketConnection(conn)
+			logger.Print(`[fasthttp-socket-handler] closed connection`)
+		}()
+	}
+}
+
+func isTemporaryError(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Temporary()
+}
+
+// waitAcceptRetry logs the temporary error and sleeps before the next retry.
+// Returns the delay for the next call (the delay doubles up to
+// maxAcceptRetryDelay).
+func (sock *SocketServer) waitAcceptRetry(err error, delay time.Duration) time.Duration {
+	if delay == 0 {
+		delay = minAcceptRetryDelay
+	} else if delay *= 2; delay > maxAcceptRetryDelay {
+		delay = maxAcceptRetryDelay
+	}
+	sock.Logger.Errorf("[fasthttp-socket] got error: %v; retrying in %v\n", err, delay)
+	time.Sleep(delay)
+	return delay
+}
+
+func (sock *SocketServer) isStopped() (r bool) {
+	sock.LockDo(func() {
+		r = sock.stopped
+	})
+	return
+}
+
+// trackListener adds (or removes) the listener (or the net.PacketConn) to be
+// closed by Stop. Returns false if the server is already stopped.
+func (sock *SocketServer) trackListener(listener io.Closer, add bool) (r bool) {
+	sock.LockDo(func() {
+		if !add {
+			delete(sock.listeners, listener)
+			return
+		}
+		if sock.stopped {
+			return
+		}
+		if sock.listeners == nil {
+			sock.listeners = map[io.Closer]struct{}{}
+		}
+		sock.listeners[listener] = struct{}{}
+		r = true
+	})
+	return
+}
+
+// trackConn adds (or removes) the connection to be closed by Stop. Returns
+// false if the server is already stopped.
+func (sock *SocketServer) trackConn(conn net.Conn, add bool) (r bool) {
+	sock.LockDo(func() {
+		if !add {
+			delete(sock.conns, conn)
+			return
+		}
+		if sock.stopped {
+			return
+		}
+		if sock.conns == nil {
+			sock.conns = map[net.Conn]struct{}{}
+		}
+		sock.conns[conn] = struct{}{}
+		r = true
+	})
+	return
+}
+
+func (sock *SocketServer) isUnixFamily() bool {
+	switch sock.Family {
+	case FamilyUnixStream, FamilyUnixGram, FamilyUnixPacket:
+		return true
+	}
+	return false
+}
+
 func (sock *SocketServer) handleSocketConnection(conn net.Conn) {
-	msg := NewMessanger(conn)
+	msg := newTimeoutReadWriter(NewMessanger(conn), conn, sock.ReadTimeout, sock.WriteTimeout, sock.IdleTimeout)
 
 	encoder := sock.NewEncoderFunc(msg)
 	decoder := sock.NewDecoderFunc(msg)
@@ -86,53 +325,228 @@ func (sock *SocketServer) handleSocketConnection(conn net.Conn) {
 	modelCodec := sock.DataModel.GetServerCodec()
 
 	request := modelCodec.GetRequest()
+	if _, ok := request.(requestIDCarrier); ok && sock.ConnConcurrency > 1 {
+		request.Release()
+		sock.handleSocketConnectionConcurrently(conn, msg, encoder, decoder, modelCodec)
+		return
+	}
 	response := modelCodec.GetResponse()
 
-	requestCtx := fasthttp.RequestCtx{}
+	requestCtx := &fasthttp.RequestCtx{}
 
 	for {
+		request.Reset()
+		startIdle(msg)
 		err := decoder.Decode(request)
 		if err != nil {
-			netErr, _ := err.(*net.OpError)
-			switch {
-			case err == io.EOF, netErr != nil && netErr.Err == io.EOF:
-			default:
-				logger.Errorf(`[fasthttp-socket-handler] got error (and closing): %v\n`, err)
-			}
+			sock.logDecodeError(msg, err)
 			break
 		}
 
-		err = modelCodec.Decode(&requestCtx, request)
+		requestCtx.ResetUserValues() // they could be set by the handler of the previous request
+		isCtxInUse, err := sock.processRequest(requestCtx, modelCodec, request, response)
+		if isCtxInUse {
+			requestCtx = &fasthttp.RequestCtx{}
+		}
 		if err != nil {
-			logger.Errorf(`[fasthttp-socket-handler] unable parse the request: %v\n`, err)
+			logger.Errorf("[fasthttp-socket-handler] %v\n", err)
 			break
 		}
 
-		err = sock.HandleRequester.HandleRequest(&requestCtx)
+		err = encoder.Encode(response)
 		if err != nil {
-			logger.Errorf(`[fasthttp-socket-handler] unable process the request: %v\n`, err)
+			logger.Errorf(`[fasthttp-socket-handler] unable to send a message: %v\n`, err)
 			break
 		}
 
-		err = modelCodec.Encode(response, &requestCtx)
-		if err != nil {
-			logger.Errorf(`[fasthttp-socket-handler] unable convert the response: %v\n`, err)
+		if closer, ok := request.(connectionCloser); ok && closer.ConnectionClose() {
 			break
 		}
+	}
 
-		err = encoder.Encode(response)
+	request.Release()
+	response.Release()
+
+	_ = conn.Close()
+}
+
+// handleSocketConnectionConcurrently handles up to ConnConcurrency requests
+// of the connection at once. It's used only for data models which carry
+// request IDs, so responses could be sent in any order. SocketClient sends
+// requests of a connection one by one, so only pipelined requests (see
+// SocketClient.SendBatch) are handled concurrently.
+func (sock *SocketServer) handleSocketConnectionConcurrently(
+	conn net.Conn,
+	msg io.ReadWriter,
+	encoder Encoder,
+	decoder Decoder,
+	modelCodec ServerCodec,
+) {
+	logger := sock.Logger
+
+	var encoderLocker sync.Mutex
+	requests := make(chan TransmittableRequest, sock.ConnConcurrency)
+
+	var wg sync.WaitGroup
+	for i := 0; i < sock.ConnConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			workerCodec := sock.DataModel.GetServerCodec()
+			response := workerCodec.GetResponse()
+			defer response.Release()
+
+			for request := range requests {
+				requestCtx := acquireServerRequestCtx()
+				isCtxInUse, err := sock.processRequest(requestCtx, workerCodec, request, response)
+				request.Release()
+				if err == nil {
+					encoderLocker.Lock()
+					err = encoder.Encode(response)
+					encoderLocker.Unlock()
+					if err != nil {
+						err = errors.Wrap(err, `unable to send a message`)
+					}
+				}
+				if !isCtxInUse {
+					releaseServerRequestCtx(requestCtx)
+				}
+				finishHandling(msg)
+
+				if err != nil {
+					logger.Errorf("[fasthttp-socket-handler] %v\n", err)
+					_ = conn.Close() // the reading loop will stop
+				}
+			}
+		}()
+	}
+
+	for {
+		request := modelCodec.GetRequest()
+		startIdle(msg)
+		err := decoder.Decode(request)
 		if err != nil {
-			logger.Errorf(`[fasthttp-socket-handler] unable to send a message: %v\n`, err)
+			request.Release()
+			sock.logDecodeError(msg, err)
 			break
 		}
+		startHandling(msg)
+		requests <- request
 	}
 
-	request.Release()
-	response.Release()
+	close(requests)
+	wg.Wait()
 
 	_ = conn.Close()
 }
 
+// logDecodeError logs an error of reading a request from "msg" unless it's a
+// normal close of the connection (by the client or by IdleTimeout)
+func (sock *SocketServer) logDecodeError(msg io.ReadWriter, err error) {
+	netErr, _ := err.(*net.OpError)
+	switch {
+	case err == io.EOF, netErr != nil && netErr.Err == io.EOF, isIdleTimeout(msg, err):
+	default:
+		sock.Logger.Errorf(`[fasthttp-socket-handler] got error (and closing): %v\n`, err)
+	}
+}
+
+// processRequest converts a decoded request into "ctx", handles it and
+// converts the response into "response". An error means that the connection
+// should be closed. isCtxInUse == true means that the handler exceeded
+// HandlerTimeout and still uses "ctx".
+func (sock *SocketServer) processRequest(
+	ctx *fasthttp.RequestCtx,
+	modelCodec ServerCodec,
+	request TransmittableRequest,
+	response TransmittableResponse,
+) (isCtxInUse bool, err error) {
+	ctx.Response.Reset()
+
+	err = modelCodec.Decode(ctx, request)
+	if err != nil {
+		return false, errors.Wrap(err, `unable parse the request`)
+	}
+	if carrier, ok := request.(connInfoCarrier); ok {
+		carrier.connInfo().apply(ctx, sock.Logger)
+	}
+	if carrier, ok := request.(userValuesCarrier); ok {
+		carrier.userValues().apply(ctx, sock.UserValueKeys, true)
+	}
+
+	if sock.requestSlots.acquire(sock.MaxQueueWait) {
+		isCtxInUse, err = sock.handleRequestWithTimeout(ctx)
+		if err != nil {
+			return false, errors.Wrap(err, `unable process the request`)
+		}
+		if isCtxInUse {
+			sock.Logger.Errorf("[fasthttp-socket-handler] the handler exceeded the timeout %v\n", sock.HandlerTimeout)
+			ctx = newResponseCtx(sock.HandlerTimeoutResponse)
+		}
+	} else {
+		ctx = newResponseCtx(sock.OverloadResponse)
+	}
+
+	return isCtxInUse, sock.encodeResponse(ctx, modelCodec, request, response)
+}
+
+// encodeResponse converts the response of "ctx" into "response" (to be sent
+// as the answer to "request").
+func (sock *SocketServer) encodeResponse(
+	ctx *fasthttp.RequestCtx,
+	modelCodec ServerCodec,
+	request TransmittableRequest,
+	response TransmittableResponse,
+) error {
+	err := modelCodec.Encode(response, ctx)
+	if err != nil {
+		return errors.Wrap(err, `unable convert the response`)
+	}
+	if carrier, ok := response.(userValuesCarrier); ok {
+		carrier.userValues().fill(ctx, sock.ResponseUserValueKeys)
+	}
+	if requestCarrier, ok := request.(requestIDCarrier); ok {
+		if responseCarrier, ok := response.(requestIDCarrier); ok {
+			*responseCarrier.requestID() = *requestCarrier.requestID()
+		}
+	}
+	return nil
+}
+
+// handleRequest calls the handler and recovers its panics: a panic is logged
+// and the client gets "500 Internal Server Error" (the connection remains
+// usable). The slot of requestSlots is released when the handler finishes.
+func (sock *SocketServer) handleRequest(ctx *fasthttp.RequestCtx) (err error) {
+	defer sock.requestSlots.release()
+	defer func() {
+		if r := recover(); r != nil {
+			handlePanic(sock.Logger, ctx, r)
+			err = nil
+		}
+	}()
+	return sock.handler.HandleRequest(ctx)
+}
+
+// Stop closes listeners (Serve and ServePacket return ErrServerClosed) and
+// connections of the server. Handlers which are in progress are not waited for.
 func (sock *SocketServer) Stop() error {
-	return ErrNotImplemented
+	var closers []io.Closer
+	sock.LockDo(func() {
+		sock.stopped = true
+		for listener := range sock.listeners {
+			closers = append(closers, listener)
+		}
+		for conn := range sock.conns {
+			closers = append(closers, conn)
+		}
+	})
+
+	var firstErr error
+	for _, closer := range closers {
+		if err := closer.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
@@ -2,6 +2,12 @@ package fasthttpsocket
 
 import (
 	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -59,3 +65,372 @@ func TestUnixGram(t *testing.T) {
 	}()
 	time.Sleep(time.Second)
 }
+
+func TestServerUnixSocketPermissions(t *testing.T) {
+	for _, family := range []string{"unix", "unixpacket", "unixgram"} {
+		path := filepath.Join(t.TempDir(), family+".sock")
+		srv, err := NewSocketServer(&testHandleRequester{}, Config{
+			Address: "raw:native:" + family + ":" + path,
+		})
+		if !assert.NoError(t, err, family) {
+			continue
+		}
+		if !assert.NoError(t, srv.Start(), family) {
+			continue
+		}
+		info, err := os.Stat(path)
+		if assert.NoError(t, err, family) {
+			// the default permissions are set by umask, so the owner can connect
+			assert.Equal(t, os.FileMode(0600), info.Mode().Perm()&0600, family)
+		}
+		assert.NoError(t, srv.Stop(), family)
+	}
+
+	path := filepath.Join(t.TempDir(), "custom.sock")
+	srv := startTestServer(t, "raw:native:unix:"+path, nil)
+	defer srv.Stop()
+	info, err := os.Stat(path)
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+	}
+}
+
+func TestServeStop(t *testing.T) {
+	srv, err := NewSocketServer(&testHandleRequester{}, Config{
+		Address: testUnixAddress,
+		Logger:  &testErrorLogger{t},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	listener, err := net.Listen("unix", filepath.Join(t.TempDir(), "serve.sock"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	result := make(chan error, 1)
+	go func() {
+		result <- srv.Serve(listener)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, srv.Stop())
+	select {
+	case err := <-result:
+		assert.Equal(t, ErrServerClosed, err)
+	case <-time.After(time.Second):
+		t.Error("Serve didn't return after Stop")
+	}
+	assert.Equal(t, ErrServerClosed, srv.Serve(listener))
+}
+
+func TestServePacketStop(t *testing.T) {
+	srv, err := NewSocketServer(&testHandleRequester{}, Config{
+		Address: testUnixAddress,
+		Logger:  &testErrorLogger{t},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	result := make(chan error, 1)
+	go func() {
+		result <- srv.ServePacket(packetConn)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, srv.Stop())
+	select {
+	case err := <-result:
+		assert.Equal(t, ErrServerClosed, err)
+	case <-time.After(time.Second):
+		t.Error("ServePacket didn't return after Stop")
+	}
+}
+
+func TestPacketPeerConn(t *testing.T) {
+	var closedPeer *packetPeerConn
+	peer := newPacketPeerConn(nil, &net.UDPAddr{}, 0, func(peer *packetPeerConn) {
+		closedPeer = peer
+	})
+	assert.True(t, peer.push([]byte(`first`)))
+	assert.True(t, peer.push([]byte(`second`)))
+	buf := make([]byte, 16)
+	n, err := peer.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, `first`, string(buf[:n]))
+
+	// an expired deadline wins over queued datagrams
+	assert.NoError(t, peer.SetReadDeadline(time.Now().Add(-time.Second)))
+	_, err = peer.Read(buf)
+	netErr, ok := err.(net.Error)
+	assert.True(t, ok && netErr.Timeout())
+
+	assert.NoError(t, peer.SetReadDeadline(time.Time{}))
+	n, err = peer.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, `second`, string(buf[:n]))
+
+	assert.NoError(t, peer.SetReadDeadline(time.Now().Add(10*time.Millisecond)))
+	_, err = peer.Read(buf)
+	netErr, ok = err.(net.Error)
+	assert.True(t, ok && netErr.Timeout())
+
+	// a blocked Read follows a new deadline
+	assert.NoError(t, peer.SetReadDeadline(time.Time{}))
+	readResult := make(chan error, 1)
+	go func() {
+		_, err := peer.Read(buf)
+		readResult <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, peer.SetReadDeadline(time.Now()))
+	select {
+	case err := <-readResult:
+		netErr, ok = err.(net.Error)
+		assert.True(t, ok && netErr.Timeout())
+	case <-time.After(time.Second):
+		t.Error("Read didn't follow the new deadline")
+	}
+
+	// a closed peer wins over queued datagrams and deadlines
+	assert.True(t, peer.push([]byte(`third`)))
+	assert.NoError(t, peer.SetReadDeadline(time.Now().Add(-time.Second)))
+	assert.NoError(t, peer.Close())
+	assert.True(t, peer.isClosed())
+	assert.Equal(t, peer, closedPeer)
+	_, err = peer.Read(buf)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestPacketPeerConnIdleTimeout(t *testing.T) {
+	peer := newPacketPeerConn(nil, &net.UDPAddr{}, 10*time.Millisecond, func(peer *packetPeerConn) {})
+	assert.True(t, peer.push([]byte(`first`)))
+	buf := make([]byte, 16)
+	n, err := peer.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, `first`, string(buf[:n]))
+
+	// a read deadline overrides the idle timeout
+	assert.NoError(t, peer.SetReadDeadline(time.Now().Add(50*time.Millisecond)))
+	startTime := time.Now()
+	_, err = peer.Read(buf)
+	netErr, ok := err.(net.Error)
+	assert.True(t, ok && netErr.Timeout())
+	assert.True(t, time.Since(startTime) >= 50*time.Millisecond)
+
+	assert.NoError(t, peer.SetReadDeadline(time.Time{}))
+	_, err = peer.Read(buf)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestServeUnixGram(t *testing.T) {
+	address := "fasthttp:native:unixgram:" + filepath.Join(t.TempDir(), "unixgram.sock")
+	logger := &testRecordingLogger{}
+	srv, err := NewSocketServerWithRequestHandler(func(ctx *fasthttp.RequestCtx) {
+		ctx.SetBody(ctx.Path())
+	}, Config{
+		Address:               address,
+		UnixSocketPermissions: 0700,
+		Logger:                logger,
+		MaxConns:              1,
+		IdleTimeout:           50 * time.Millisecond,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.NoError(t, srv.Start()) {
+		return
+	}
+	defer srv.Stop()
+
+	sendRequest := func(path string) {
+		client, err := NewSocketClient(Config{Address: address})
+		if !assert.NoError(t, err) {
+			return
+		}
+		if !assert.NoError(t, client.Start(1)) {
+			return
+		}
+		localAddr := client.clientConns[0].LocalAddr().String()
+		defer func() {
+			client.Close()
+			_, err := os.Stat(localAddr)
+			assert.True(t, os.IsNotExist(err), "the local address isn't removed")
+		}()
+
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI(path)
+		if assert.NoError(t, client.SendAndReceive(ctx)) {
+			assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+			assert.Equal(t, path, string(ctx.Response.Body()))
+		}
+	}
+
+	sendRequest("/first")
+
+	// the peer is forgotten after IdleTimeout, so the next one gets the slot
+	assert.True(t, waitFor(func() bool {
+		var connCount int
+		srv.LockDo(func() {
+			connCount = len(srv.conns)
+		})
+		return connCount == 0
+	}))
+	sendRequest("/second")
+	assert.Empty(t, logger.Errors())
+}
+
+func TestServerHandlerPanic(t *testing.T) {
+	address := "fasthttp:native:unixpacket:" + filepath.Join(t.TempDir(), "panic.sock")
+	logger := &testRecordingLogger{}
+	srv, err := NewSocketServerWithRequestHandler(func(ctx *fasthttp.RequestCtx) {
+		if string(ctx.Path()) == "/panic" {
+			panic("test panic")
+		}
+		ctx.SetBodyString("ok")
+	}, Config{
+		Address:               address,
+		UnixSocketPermissions: 0700,
+		Logger:                logger,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.NoError(t, srv.Start()) {
+		return
+	}
+	defer srv.Stop()
+
+	client, err := NewSocketClient(Config{Address: address})
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.NoError(t, client.Start(1)) {
+		return
+	}
+	defer client.Close()
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/panic")
+	assert.NoError(t, client.SendAndReceive(ctx))
+	assert.Equal(t, fasthttp.StatusInternalServerError, ctx.Response.StatusCode())
+	if assert.Len(t, logger.Errors(), 1) {
+		assert.Contains(t, logger.Errors()[0], "panic while handling GET /panic: test panic")
+	}
+
+	// the next request is handled via the same connection
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/ok")
+	assert.NoError(t, client.SendAndReceive(ctx))
+	assert.Equal(t, fasthttp.StatusOK, ctx.Response.StatusCode())
+	assert.Equal(t, "ok", string(ctx.Response.Body()))
+	assert.Len(t, logger.Errors(), 1)
+	srv.LockDo(func() {
+		assert.Len(t, srv.conns, 1)
+	})
+}
+
+func TestServerConnConcurrency(t *testing.T) {
+	address := "fasthttp:native:unixpacket:" + filepath.Join(t.TempDir(), "concurrency.sock")
+	const connConcurrency = 4
+	var inFlight, maxInFlight int32
+	allInFlight := make(chan struct{})
+	var allInFlightOnce sync.Once
+	srv, err := NewSocketServerWithRequestHandler(func(ctx *fasthttp.RequestCtx) {
+		count := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if count <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, count) {
+				break
+			}
+		}
+		if count == connConcurrency {
+			allInFlightOnce.Do(func() { close(allInFlight) })
+		}
+		select { // handlers of a batch are waiting for each other
+		case <-allInFlight:
+		case <-time.After(time.Second):
+		}
+		ctx.SetBody(ctx.Path())
+	}, Config{
+		Address:               address,
+		UnixSocketPermissions: 0700,
+		ConnConcurrency:       connConcurrency,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.NoError(t, srv.Start()) {
+		return
+	}
+	defer srv.Stop()
+
+	client, err := NewSocketClient(Config{Address: address})
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.NoError(t, client.Start(1)) {
+		return
+	}
+	defer client.Close()
+
+	ctxs := make([]*fasthttp.RequestCtx, 2*connConcurrency)
+	for idx := range ctxs {
+		ctxs[idx] = &fasthttp.RequestCtx{}
+		ctxs[idx].Request.SetRequestURI(fmt.Sprintf("/%d", idx))
+	}
+	startTime := time.Now()
+	for idx, err := range client.SendBatch(ctxs) {
+		if assert.NoError(t, err) {
+			assert.Equal(t, fmt.Sprintf("/%d", idx), string(ctxs[idx].Response.Body()))
+		}
+	}
+	assert.True(t, time.Since(startTime) < time.Second, "the handlers waited for each other")
+	assert.Equal(t, int32(connConcurrency), atomic.LoadInt32(&maxInFlight))
+}
+
+func TestServerResetsUserValues(t *testing.T) {
+	address := "fasthttp:native:unixpacket:" + filepath.Join(t.TempDir(), "user-values.sock")
+	srv, err := NewSocketServerWithRequestHandler(func(ctx *fasthttp.RequestCtx) {
+		if value := ctx.UserValue("handler-value"); value != nil {
+			ctx.SetBodyString("leaked " + value.(string))
+			return
+		}
+		ctx.SetUserValue("handler-value", string(ctx.Path()))
+		ctx.SetBodyString("ok")
+	}, Config{
+		Address:               address,
+		UnixSocketPermissions: 0700,
+		ConnConcurrency:       1, // requests are handled one by one with the same RequestCtx
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.NoError(t, srv.Start()) {
+		return
+	}
+	defer srv.Stop()
+
+	client, err := NewSocketClient(Config{Address: address})
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.NoError(t, client.Start(1)) {
+		return
+	}
+	defer client.Close()
+
+	for _, path := range []string{"/first", "/second"} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI(path)
+		if assert.NoError(t, client.SendAndReceive(ctx)) {
+			assert.Equal(t, "ok", string(ctx.Response.Body()), path)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package fasthttpsocket
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testUnmarshaler struct {
+	b []byte
+}
+
+func (u *testUnmarshaler) Unmarshal(b []byte) {
+	u.b = append(u.b[:0], b...)
+}
+
+type testCheckedUnmarshaler struct {
+	b []byte
+}
+
+func (u *testCheckedUnmarshaler) Unmarshal(b []byte) error {
+	if len(b) == 0 {
+		return ErrInvalidMessage
+	}
+	u.b = append(u.b[:0], b...)
+	return nil
+}
+
+func TestDummyDecoderUnmarshalers(t *testing.T) {
+	unmarshaler := &testUnmarshaler{}
+	assert.NoError(t, newDummyDecoder(bytes.NewReader([]byte(`message`))).Decode(unmarshaler))
+	assert.Equal(t, `message`, string(unmarshaler.b))
+
+	checkedUnmarshaler := &testCheckedUnmarshaler{}
+	assert.NoError(t, newDummyDecoder(bytes.NewReader([]byte(`message`))).Decode(checkedUnmarshaler))
+	assert.Equal(t, `message`, string(checkedUnmarshaler.b))
+
+	assert.Equal(t, ErrNoNativeUnmarshaler, newDummyDecoder(bytes.NewReader([]byte(`message`))).Decode(struct{}{}))
+}
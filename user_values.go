@@ -0,0 +1,116 @@
+package fasthttpsocket
+
+import (
+	"encoding"
+	"fmt"
+	"strconv"
+
+	"github.com/trafficstars/fasthttp"
+)
+
+// UserValue is a fasthttp.RequestCtx user value transferred between
+// SocketClient and SocketServer (see Config.UserValueKeys).
+type UserValue struct {
+	Key   string
+	Value string
+}
+
+type UserValues []UserValue
+
+type userValuesCarrier interface {
+	userValues() *UserValues
+}
+
+func userValueToString(value interface{}) (string, bool) {
+	switch value := value.(type) {
+	case string:
+		return value, true
+	case []byte:
+		return string(value), true
+	case encoding.TextMarshaler:
+		b, err := value.MarshalText()
+		return string(b), err == nil
+	case fmt.Stringer:
+		return value.String(), true
+	case int:
+		return strconv.Itoa(value), true
+	case int64:
+		return strconv.FormatInt(value, 10), true
+	case uint64:
+		return strconv.FormatUint(value, 10), true
+	case bool:
+		return strconv.FormatBool(value), true
+	}
+	return "", false
+}
+
+func (values *UserValues) Reset() {
+	*values = (*values)[:0]
+}
+
+// fill collects user values with keys "keys" from "ctx". Values of unsupported
+// types are skipped.
+func (values *UserValues) fill(ctx *fasthttp.RequestCtx, keys []string) {
+	values.Reset()
+	for _, key := range keys {
+		value, ok := userValueToString(ctx.UserValue(key))
+		if !ok {
+			continue
+		}
+		*values = append(*values, UserValue{Key: key, Value: value})
+	}
+}
+
+func (values UserValues) get(key string) (string, bool) {
+	for _, userValue := range values {
+		if userValue.Key == key {
+			return userValue.Value, true
+		}
+	}
+	return "", false
+}
+
+// apply sets user values with keys "keys" to "ctx". If "reset" is true then
+// the keys missing in "values" are set to nil.
+func (values UserValues) apply(ctx *fasthttp.RequestCtx, keys []string, reset bool) {
+	for _, key := range keys {
+		value, ok := values.get(key)
+		switch {
+		case ok:
+			ctx.SetUserValue(key, value)
+		case reset:
+			ctx.SetUserValue(key, nil)
+		}
+	}
+}
+
+func (values UserValues) marshalTo(dst []byte) []byte {
+	dst = appendUvarint(dst, uint64(len(values)))
+	for _, userValue := range values {
+		dst = appendString(dst, userValue.Key)
+		dst = appendString(dst, userValue.Value)
+	}
+	return dst
+}
+
+func (values *UserValues) unmarshalFrom(src []byte) (_ []byte, err error) {
+	values.Reset()
+	count, src, err := readUvarint(src)
+	if err != nil {
+		return src, err
+	}
+	if count > uint64(len(src)) { // each value takes at least two bytes
+		return src, ErrInvalidMessage
+	}
+	for i := uint64(0); i < count; i++ {
+		var userValue UserValue
+		if userValue.Key, src, err = readString(src); err != nil {
+			return src, err
+		}
+		if userValue.Value, src, err = readString(src); err != nil {
+			return src, err
+		}
+		*values = append(*values, userValue)
+	}
+	return src, nil
+}